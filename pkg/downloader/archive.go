@@ -0,0 +1,159 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+	"github.com/sirupsen/logrus"
+)
+
+// archiveEntry describes one media file that has already been downloaded.
+type archiveEntry struct {
+	TweetID  string `json:"tweet_id"`
+	MediaKey string `json:"media_key"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Hash     string `json:"sha256"`
+}
+
+// downloadArchive keeps track of downloaded media so that Update does not
+// depend on the naming template. Entries are appended as JSON lines.
+type downloadArchive struct {
+	path    string
+	lock    sync.Mutex
+	entries map[string]archiveEntry
+}
+
+func openArchive(path string, log *logrus.Logger) (*downloadArchive, error) {
+	a := &downloadArchive{path: path, entries: map[string]archiveEntry{}}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e archiveEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			log.Warnf("Skipping invalid archive line in %s: %s", path, err.Error())
+			continue
+		}
+		a.entries[e.TweetID+"/"+e.MediaKey] = e
+	}
+	return a, scanner.Err()
+}
+
+func (a *downloadArchive) has(tweetID, key string) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, ok := a.entries[tweetID+"/"+key]
+	return ok
+}
+
+func (a *downloadArchive) add(e archiveEntry) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.entries[e.TweetID+"/"+e.MediaKey] = e
+
+	js, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(js, '\n'))
+	return err
+}
+
+// hashFile returns the size and the sha256 of a file.
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// archive returns the archive used for the media saved in output: the
+// shared ArchiveFile or output/twmd_archive.jsonl.
+func (d *Downloader) archive(output string) (*downloadArchive, error) {
+	path := d.opts.ArchiveFile
+	if path == "" {
+		path = output + "/twmd_archive.jsonl"
+	}
+
+	d.archivesLock.Lock()
+	defer d.archivesLock.Unlock()
+	if a, ok := d.archives[path]; ok {
+		return a, nil
+	}
+	a, err := openArchive(path, d.log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", path, err)
+	}
+	d.log.Infof("Using archive %s (%d entries)", path, len(a.entries))
+	d.archives[path] = a
+	return a, nil
+}
+
+// mediaKey returns the media identifier of a twimg url, which stays the same
+// whatever the name of the downloaded file is.
+func mediaKey(url string) string {
+	name := strings.Split(url, "?")[0]
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimSuffix(name, "."+strings.Split(name, ".")[len(strings.Split(name, "."))-1])
+}
+
+func tweetID(tweet interface{}) string {
+	switch t := tweet.(type) {
+	case *twitterscraper.TweetResult:
+		return t.ID
+	case *twitterscraper.Tweet:
+		return t.ID
+	}
+	return ""
+}
+
+// archived reports whether the media was already downloaded in a previous run.
+// The archive is only consulted with Update.
+func (d *Downloader) archived(tweet interface{}, url string, output string) bool {
+	if !d.opts.Update {
+		return false
+	}
+	a, err := d.archive(output)
+	if err != nil {
+		return false
+	}
+	if a.has(tweetID(tweet), mediaKey(url)) {
+		d.log.Infof("Already in archive: %s", mediaKey(url))
+		return true
+	}
+	return false
+}
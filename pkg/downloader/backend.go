@@ -0,0 +1,37 @@
+package downloader
+
+import (
+	"net/http"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// backend is the part of the twitter scraper used by the Downloader. It is
+// implemented by scraperBackend, and by a fake replaying recorded tweets in
+// tests.
+type backend interface {
+	GetTweet(id string) (*twitterscraper.Tweet, error)
+	GetTweetReplies(id string, cursor string) ([]*twitterscraper.Tweet, []*twitterscraper.ThreadCursor, error)
+	FetchTweets(user string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error)
+	FetchMediaTweets(user string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error)
+	FetchBookmarks(maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error)
+	FetchSearchTweets(query string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error)
+	SetSearchMode(mode twitterscraper.SearchMode)
+	GetProfile(username string) (twitterscraper.Profile, error)
+	GetUserIDByScreenName(screenName string) (string, error)
+	RequestAPI(req *http.Request, target interface{}) error
+
+	SetCookies(cookies []*http.Cookie)
+	GetCookies() []*http.Cookie
+	SetAuthToken(token twitterscraper.AuthToken)
+	IsLoggedIn() bool
+}
+
+// scraperBackend is the backend of a twitter scraper.
+type scraperBackend struct {
+	*twitterscraper.Scraper
+}
+
+func (s scraperBackend) SetSearchMode(mode twitterscraper.SearchMode) {
+	s.Scraper.SetSearchMode(mode)
+}
@@ -0,0 +1,389 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeBackend replays the tweets recorded in testdata instead of calling
+// twitter. Media urls of the fixtures point to a mediaServer.
+type fakeBackend struct {
+	mediaURL  string
+	timeline  []*twitterscraper.Tweet
+	bookmarks []*twitterscraper.Tweet
+	tweets    map[string]*twitterscraper.Tweet
+	pageSize  int
+
+	searchMode twitterscraper.SearchMode
+	profiles   map[string]*twitterscraper.Profile
+
+	lock     sync.Mutex
+	cookies  []*http.Cookie
+	token    twitterscraper.AuthToken
+	requests []string
+}
+
+var _ backend = scraperBackend{}
+var _ backend = (*fakeBackend)(nil)
+
+func newFakeBackend(t *testing.T, mediaURL string) *fakeBackend {
+	t.Helper()
+	f := &fakeBackend{mediaURL: mediaURL, tweets: map[string]*twitterscraper.Tweet{}, pageSize: 2}
+	if err := f.readFixture("timeline.json", &f.timeline); err != nil {
+		t.Fatal(err)
+	}
+	for _, tweet := range f.timeline {
+		f.tweets[tweet.ID] = tweet
+	}
+	if err := f.readFixture("profiles.json", &f.profiles); err != nil {
+		t.Fatal(err)
+	}
+	var quotes []*twitterscraper.Tweet
+	if err := f.readFixture("quotes.json", &quotes); err != nil {
+		t.Fatal(err)
+	}
+	for _, tweet := range quotes {
+		f.tweets[tweet.ID] = tweet
+	}
+	// The tweets of the conversations can be fetched alone too.
+	pages, _ := filepath.Glob(filepath.Join("testdata", "conversation_*.json"))
+	for _, name := range pages {
+		var page conversationPage
+		if err := f.readFixture(filepath.Base(name), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, tweet := range page.Tweets {
+			f.tweets[tweet.ID] = tweet
+		}
+	}
+	// Bookmarks are ordered by the time they were added.
+	f.bookmarks = []*twitterscraper.Tweet{f.tweets["1000"], f.tweets["1003"], f.tweets["1002"]}
+	return f
+}
+
+// readFixture decodes a testdata file, with {{MEDIA}} replaced by the url of
+// the media server.
+func (f *fakeBackend) readFixture(name string, target interface{}) error {
+	js, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		return err
+	}
+	js = []byte(strings.ReplaceAll(string(js), "{{MEDIA}}", f.mediaURL))
+	return json.Unmarshal(js, target)
+}
+
+func (f *fakeBackend) record(request string) {
+	f.lock.Lock()
+	f.requests = append(f.requests, request)
+	f.lock.Unlock()
+}
+
+func (f *fakeBackend) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	f.record("GetTweet " + id)
+	tweet, ok := f.tweets[id]
+	if !ok {
+		return nil, fmt.Errorf("tweet %s not found", id)
+	}
+	copied := *tweet
+	return &copied, nil
+}
+
+// conversationPage is a TweetDetail response, saved in
+// testdata/conversation_ID.json, or conversation_ID_CURSOR.json for the
+// next pages.
+type conversationPage struct {
+	Tweets  []*twitterscraper.Tweet
+	Cursors []*twitterscraper.ThreadCursor
+}
+
+func (f *fakeBackend) GetTweetReplies(id string, cursor string) ([]*twitterscraper.Tweet, []*twitterscraper.ThreadCursor, error) {
+	f.record("GetTweetReplies " + id + " " + cursor)
+	name := "conversation_" + id + ".json"
+	if cursor != "" {
+		name = "conversation_" + id + "_" + cursor + ".json"
+	}
+	var page conversationPage
+	err := f.readFixture(name, &page)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, errors.New("response status 404 Not Found")
+	}
+	return page.Tweets, page.Cursors, err
+}
+
+func (f *fakeBackend) fetch(tweets []*twitterscraper.Tweet, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	start := 0
+	if cursor != "" {
+		var err error
+		if start, err = strconv.Atoi(cursor); err != nil {
+			return nil, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+	}
+	if start >= len(tweets) {
+		return nil, "", nil
+	}
+	end := start + f.pageSize
+	if end > len(tweets) {
+		end = len(tweets)
+	}
+	next := ""
+	if end < len(tweets) {
+		next = strconv.Itoa(end)
+	}
+	return tweets[start:end], next, nil
+}
+
+func (f *fakeBackend) FetchTweets(user string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	f.record("FetchTweets " + user + " " + cursor)
+	return f.fetch(f.timeline, maxTweetsNbr, cursor)
+}
+
+func (f *fakeBackend) FetchMediaTweets(user string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	f.record("FetchMediaTweets " + user + " " + cursor)
+	var media []*twitterscraper.Tweet
+	for _, tweet := range f.timeline {
+		if len(tweet.Photos)+len(tweet.Videos)+len(tweet.GIFs) > 0 {
+			media = append(media, tweet)
+		}
+	}
+	return f.fetch(media, maxTweetsNbr, cursor)
+}
+
+func (f *fakeBackend) FetchBookmarks(maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	f.record("FetchBookmarks " + cursor)
+	if !f.IsLoggedIn() {
+		return nil, "", errors.New("response status 401 Unauthorized")
+	}
+	return f.fetch(f.bookmarks, maxTweetsNbr, cursor)
+}
+
+// FetchSearchTweets returns the tweets of the timeline containing all the
+// words of the query. filter:media keeps the tweets with media.
+func (f *fakeBackend) FetchSearchTweets(query string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	f.record(fmt.Sprintf("FetchSearchTweets %d %s %s", f.searchMode, query, cursor))
+	if !f.IsLoggedIn() {
+		return nil, "", errors.New("scraper is not logged in for search")
+	}
+	var results []*twitterscraper.Tweet
+	for _, tweet := range f.timeline {
+		match := true
+		for _, word := range strings.Fields(strings.ToLower(query)) {
+			if word == "filter:media" {
+				match = match && len(tweet.Photos)+len(tweet.Videos)+len(tweet.GIFs) > 0
+			} else {
+				match = match && strings.Contains(strings.ToLower(tweet.Text), word)
+			}
+		}
+		if match {
+			results = append(results, tweet)
+		}
+	}
+	return f.fetch(results, maxTweetsNbr, cursor)
+}
+
+func (f *fakeBackend) SetSearchMode(mode twitterscraper.SearchMode) {
+	f.searchMode = mode
+}
+
+func (f *fakeBackend) GetProfile(username string) (twitterscraper.Profile, error) {
+	f.record("GetProfile " + username)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	profile, ok := f.profiles[username]
+	if !ok {
+		return twitterscraper.Profile{}, fmt.Errorf("user %s not found", username)
+	}
+	return *profile, nil
+}
+
+func (f *fakeBackend) GetUserIDByScreenName(screenName string) (string, error) {
+	f.record("GetUserIDByScreenName " + screenName)
+	for _, tweet := range f.timeline {
+		if tweet.Username == screenName {
+			return tweet.UserID, nil
+		}
+	}
+	return "", fmt.Errorf("user %s not found", screenName)
+}
+
+// RequestAPI answers the GraphQL requests with testdata files:
+// TweetResultByRestId with tweet_result_ID.json and the timelines with
+// OPERATION.json, or OPERATION_CURSOR.json for the next pages.
+func (f *fakeBackend) RequestAPI(req *http.Request, target interface{}) error {
+	var variables struct {
+		TweetID string `json:"tweetId"`
+		Cursor  string `json:"cursor"`
+	}
+	json.Unmarshal([]byte(req.URL.Query().Get("variables")), &variables)
+	var name string
+	switch operation := path.Base(req.URL.Path); operation {
+	case "TweetResultByRestId":
+		f.record("RequestAPI " + variables.TweetID)
+		name = "tweet_result_" + variables.TweetID + ".json"
+	default:
+		f.record("RequestAPI " + operation + " " + variables.Cursor)
+		name = strings.ToLower(operation) + ".json"
+		if variables.Cursor != "" {
+			name = strings.ToLower(operation) + "_" + variables.Cursor + ".json"
+		}
+	}
+	err := f.readFixture(name, target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.New("response status 404 Not Found")
+	}
+	return err
+}
+
+func (f *fakeBackend) SetCookies(cookies []*http.Cookie) {
+	f.cookies = cookies
+}
+
+func (f *fakeBackend) GetCookies() []*http.Cookie {
+	return f.cookies
+}
+
+func (f *fakeBackend) SetAuthToken(token twitterscraper.AuthToken) {
+	f.token = token
+}
+
+func (f *fakeBackend) IsLoggedIn() bool {
+	return f.token.Token != "" || len(f.cookies) > 0
+}
+
+// mediaServer serves fake media for every path, with Range support. Paths
+// can be set up to fail a number of times first.
+type mediaServer struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	failures map[string][]int
+	requests map[string]int
+	ranges   map[string][]string
+
+	// hook, when set, is called before serving each request.
+	hook func(r *http.Request)
+}
+
+func newMediaServer(t *testing.T) *mediaServer {
+	m := &mediaServer{failures: map[string][]int{}, requests: map[string]int{}, ranges: map[string][]string{}}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// mediaContent is the body served for a path.
+func mediaContent(path string) string {
+	return strings.Repeat("media "+path+"\n", 64)
+}
+
+func (m *mediaServer) serve(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	m.requests[r.URL.Path]++
+	if rng := r.Header.Get("Range"); rng != "" {
+		m.ranges[r.URL.Path] = append(m.ranges[r.URL.Path], rng)
+	}
+	var status int
+	if codes := m.failures[r.URL.Path]; len(codes) > 0 {
+		status = codes[0]
+		m.failures[r.URL.Path] = codes[1:]
+	}
+	hook := m.hook
+	m.lock.Unlock()
+
+	if hook != nil {
+		hook(r)
+	}
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(r.URL.Path), time.Time{}, strings.NewReader(mediaContent(r.URL.Path)))
+}
+
+// fail makes the next requests of path answer with the status codes.
+func (m *mediaServer) fail(path string, codes ...int) {
+	m.lock.Lock()
+	m.failures[path] = append(m.failures[path], codes...)
+	m.lock.Unlock()
+}
+
+func (m *mediaServer) count(path string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.requests[path]
+}
+
+func (m *mediaServer) total() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	n := 0
+	for _, c := range m.requests {
+		n += c
+	}
+	return n
+}
+
+// newTestDownloader returns a Downloader using a fake backend and a media
+// server, writing in a temporary directory, that never sleeps.
+func newTestDownloader(t *testing.T, opts Options) (*Downloader, *fakeBackend, *mediaServer) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	opts.Logger = logger
+	if opts.Output == "" || opts.Output == "." {
+		opts.Output = t.TempDir()
+	}
+	opts.RetryWait = 0
+	opts.RetryJitter = 0
+
+	d, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := newMediaServer(t)
+	fake := newFakeBackend(t, media.URL)
+	d.scraper = fake
+	d.sleep = func(ctx context.Context, _ time.Duration) error { return ctx.Err() }
+	return d, fake, media
+}
+
+func TestFakeBackendPages(t *testing.T) {
+	f := newFakeBackend(t, "http://media")
+	var ids []string
+	cursor := ""
+	for {
+		tweets, next, err := f.FetchTweets("fixture_user", 100, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tweet := range tweets {
+			ids = append(ids, tweet.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if got := strings.Join(ids, ","); got != "1003,1002,1001,1000" {
+		t.Errorf("timeline = %s", got)
+	}
+	if _, err := f.GetTweet("1"); err == nil {
+		t.Error("GetTweet of an unknown tweet should fail")
+	}
+}
@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BatchLine is a tweet id, a username or a link read from a batch file.
+type BatchLine struct {
+	Line  int
+	Input string
+}
+
+// BatchItem is the outcome of a line of a batch.
+type BatchItem struct {
+	Line       int
+	Input      string
+	Target     Target
+	Tweets     int
+	Downloaded int
+	Failed     int
+	Err        error
+}
+
+// ReadBatch reads one tweet id, username or link per line. Empty lines and
+// comments, from a # at the start of a line or after a space, are skipped.
+func ReadBatch(r io.Reader) ([]BatchLine, error) {
+	var lines []BatchLine
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		if i := strings.Index(line, "\t#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, BatchLine{Line: n, Input: line})
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// DownloadBatch downloads every line of a batch in one run, sharing the
+// download queue, the rate limiter and the archives. Tweets are saved in
+// Output, users in Output/USERNAME and lists in Output/list/LIST_ID. A line
+// failing doesn't stop the batch, its error is in its BatchItem.
+func (d *Downloader) DownloadBatch(ctx context.Context, lines []BatchLine) (*Result, []BatchItem, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	var items []BatchItem
+	for _, line := range lines {
+		if ctx.Err() != nil {
+			break
+		}
+		item := BatchItem{Line: line.Line, Input: line.Input}
+		d.resultLock.Lock()
+		tweets, downloaded, failed := result.Tweets, len(result.Downloaded), len(result.Failed)
+		d.resultLock.Unlock()
+
+		item.Target, item.Err = ParseTarget(line.Input)
+		if item.Err == nil {
+			d.log.Infof("Batch line %d: %s %s", line.Line, item.Target.Kind, line.Input)
+			item.Err = d.downloadTarget(ctx, item.Target)
+		}
+
+		d.resultLock.Lock()
+		item.Tweets = result.Tweets - tweets
+		item.Downloaded = len(result.Downloaded) - downloaded
+		item.Failed = len(result.Failed) - failed
+		d.resultLock.Unlock()
+		if item.Err != nil && ctx.Err() == nil {
+			d.log.Errorf("Batch line %d (%s): %s", line.Line, line.Input, item.Err.Error())
+		}
+		items = append(items, item)
+	}
+	return result, items, ctx.Err()
+}
+
+// downloadTarget downloads a line of a batch.
+func (d *Downloader) downloadTarget(ctx context.Context, target Target) error {
+	switch target.Kind {
+	case TargetTweet:
+		output := d.opts.Output
+		os.MkdirAll(output, os.ModePerm)
+		if _, err := d.archive(output); err != nil {
+			return err
+		}
+		var err error
+		if target.MediaIndex > 0 {
+			err = d.tweetMedia(output, target.ID, target.MediaType, target.MediaIndex)
+		} else {
+			err = d.singleTweet(output, target.ID)
+		}
+		if err != nil {
+			return err
+		}
+		d.resultLock.Lock()
+		d.result.Tweets++
+		d.resultLock.Unlock()
+		return nil
+	case TargetUser, TargetList:
+		if !d.opts.Images && !d.opts.Videos && !d.opts.GIFs {
+			return errors.New("no media type selected for a timeline")
+		}
+		if target.Kind == TargetUser {
+			return d.downloadTimeline(ctx, d.userTimeline(target.Username))
+		}
+		return d.downloadTimeline(ctx, d.listTimeline(target.ID))
+	}
+	return fmt.Errorf("unsupported target %s", target.Kind)
+}
@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadBatch(t *testing.T) {
+	lines, err := ReadBatch(strings.NewReader("# tweets\n1003\n\n  https://x.com/i/status/1002 # a video\nfixture_user\t# a user\n   # indented comment\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BatchLine{{2, "1003"}, {4, "https://x.com/i/status/1002"}, {5, "fixture_user"}}
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %v", lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("lines[%d] = %v, want %v", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestDownloadBatch(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Images = true
+	opts.Update = true
+	d, _, _ := newTestDownloader(t, opts)
+	lines := []BatchLine{
+		{1, "https://x.com/fixture_user/status/1003/photo/2"},
+		{2, "fixture_user"},
+		{3, "https://example.com/fixture_user"},
+		{4, "1"},
+		{5, "1003"},
+	}
+	result, items, err := d.DownloadBatch(context.Background(), lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != len(lines) {
+		t.Fatalf("items = %v", items)
+	}
+
+	type counts struct{ tweets, downloaded int }
+	want := []counts{{1, 1}, {4, 2}, {0, 0}, {0, 0}, {1, 1}}
+	for i, item := range items {
+		if failed := i == 2 || i == 3; (item.Err != nil) != failed {
+			t.Errorf("line %d: err = %v", item.Line, item.Err)
+		}
+		if got := (counts{item.Tweets, item.Downloaded}); got != want[i] {
+			t.Errorf("line %d: %+v, want %+v", item.Line, got, want[i])
+		}
+	}
+	if items[1].Target.Kind != TargetUser || items[0].Target.MediaIndex != 2 {
+		t.Errorf("targets = %+v, %+v", items[0].Target, items[1].Target)
+	}
+
+	// photo_b was saved by the first line, so the last one only gets photo_a.
+	if result.Tweets != 6 || len(result.Downloaded) != 4 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if got := files(t, filepath.Join(d.opts.Output, "fixture_user", "img"), "*"); len(got) != 2 {
+		t.Errorf("fixture_user/img = %v", got)
+	}
+	if got := files(t, d.opts.Output, "*.jpg"); len(got) != 2 {
+		t.Errorf("files = %v", got)
+	}
+}
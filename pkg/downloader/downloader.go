@@ -0,0 +1,529 @@
+// Package downloader downloads the media of tweets and user timelines, with
+// the sidecar files (thumbnail, NFO, ASS subtitle and tweet JSON) used by
+// media servers.
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	URL "net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+	"github.com/sirupsen/logrus"
+)
+
+// Options configures a Downloader. Start from DefaultOptions and override
+// the fields you need.
+type Options struct {
+	Output string // Output directory, users are downloaded in Output/USERNAME
+
+	Images bool // Download images of user timelines
+	Videos bool // Download videos of user timelines
+	GIFs   bool // Download gifs of user timelines
+
+	Retweets        bool // Download retweets too
+	RetweetsOnly    bool // Download only retweets
+	MediaTweetsOnly bool // Crawl the media timeline instead of the tweets timeline
+	MaxTweets       int  // Maximum number of tweets crawled per user
+	Concurrency     int  // Number of media downloaded at the same time
+	FollowQuotes    bool // Download the media of quoted tweets in OUTPUT/quoted
+	QuoteDepth      int  // Levels of nested quotes followed, 1 by default
+
+	// Timeline filters, checked before any media request
+	MinLikes       int      // Skip tweets with fewer likes
+	MinRetweets    int      // Skip tweets with fewer retweets
+	MinViews       int      // Skip tweets with fewer views
+	IncludeRegex   string   // Skip tweets whose text doesn't match
+	ExcludeRegex   string   // Skip tweets whose text matches
+	Hashtags       []string // Skip tweets without one of these hashtags
+	ExcludeReplies bool     // Skip replies
+	OnlySensitive  bool     // Skip tweets not marked as sensitive
+	Filter         string   // Skip tweets not matching this expression, see filterExpr
+
+	// Media filters, from the tweet metadata
+	MinWidth    int           // Skip photos and videos narrower than this
+	MinHeight   int           // Skip photos and videos shorter than this
+	Orientation string        // Keep only portrait, landscape or square media
+	MinDuration time.Duration // Skip shorter videos and gifs
+	MaxDuration time.Duration // Skip longer videos and gifs
+	MaxFileSize int64         // Skip larger files, from a HEAD request
+
+	URLOnly       bool // Log media urls without downloading them
+	ProfileAssets bool // Save the avatar, banner and profile of users in USERNAME/profile
+	Update        bool // Skip media already in the archive
+
+	Size         string // Image size: orig, small or normal
+	FileFormat   string // Name of the media and sidecars, see nameTemplate
+	DateFormat   string // Go time layout used for {DATE}
+	VideoQuality string // best, worst, <=720p or <=BITRATE
+	GIFFormat    string // mp4, gif or webp (needs ffmpeg)
+
+	ArchiveFile string    // Shared archive, default OUTPUT/twmd_archive.jsonl
+	Resume      bool      // Continue the previous crawl from its saved cursor
+	SinceID     string    // Stop crawling at this tweet id, "last" for the previous crawl
+	Since       time.Time // Skip tweets posted before Since, stopping ordered crawls there
+	Until       time.Time // Skip tweets posted at or after Until
+	SearchTab   string    // Search results: top, latest or media
+
+	MaxRetries  int           // Maximum attempts for each request
+	RetryWait   time.Duration // Base wait between attempts, doubled each retry
+	RetryJitter time.Duration // Maximum random time added to each wait
+	RetryStatus []int         // Media status codes worth retrying
+
+	Proxy  string         // proto://ip:port
+	Logger *logrus.Logger // Defaults to the logrus standard logger
+}
+
+// DefaultOptions returns the options used by twmd when no flag is given.
+func DefaultOptions() Options {
+	return Options{
+		Output:       ".",
+		MaxTweets:    3000,
+		Concurrency:  4,
+		QuoteDepth:   1,
+		Size:         "orig",
+		DateFormat:   "2006-01-02",
+		VideoQuality: "best",
+		GIFFormat:    "mp4",
+		SearchTab:    "top",
+		MaxRetries:   3,
+		RetryWait:    10 * time.Second,
+		RetryJitter:  10 * time.Second,
+		RetryStatus:  []int{429, 500, 502, 503, 504},
+	}
+}
+
+// Media is a file written by a download.
+type Media struct {
+	TweetID string
+	URL     string
+	Path    string
+	Size    int64
+}
+
+// Failure is a media that could not be downloaded.
+type Failure struct {
+	TweetID string
+	URL     string
+	Path    string
+	Err     error
+}
+
+// Result summarizes a DownloadUser or DownloadTweet call.
+type Result struct {
+	Tweets     int
+	Downloaded []Media
+	Failed     []Failure
+}
+
+// Downloader downloads media with one scraper session, rate limiter and
+// archive. It runs one download at a time.
+type Downloader struct {
+	opts        Options
+	log         *logrus.Logger
+	scraper     backend
+	client      *http.Client
+	filter      *tweetFilter
+	name        *nameTemplate
+	retryStatus map[int]bool
+	sleep       func(context.Context, time.Duration) error
+	now         func() time.Time
+
+	run        sync.Mutex
+	ctx        context.Context
+	result     *Result
+	resultLock sync.Mutex
+	counter    int64
+	jobs       chan func()
+	workers    sync.WaitGroup
+
+	archives     map[string]*downloadArchive
+	archivesLock sync.Mutex
+
+	mediaDetails     map[string][]mediaDetail
+	mediaDetailsLock sync.Mutex
+
+	quotes     map[string]*quoteEntry
+	quotesLock sync.Mutex
+
+	// Rate limiting variables
+	requestCount     int
+	requestCountLock sync.Mutex
+	lastRequestTime  time.Time
+	lastMinuteReset  time.Time
+
+	// 429 error handling
+	consecutive429Count int
+	isCoolingDown       bool
+	coolingDownStart    time.Time
+
+	// Batch control
+	tweetCount      int
+	batchPauseCount int
+}
+
+var (
+	sizeRegex         = regexp.MustCompile("small|normal|large")
+	videoQualityRegex = regexp.MustCompile(`(?i)^(best|worst|(<=)?\d+p|(<=)?\d+[km]?)$`)
+)
+
+// New checks the options and creates a Downloader.
+func New(opts Options) (*Downloader, error) {
+	if opts.Logger == nil {
+		opts.Logger = logrus.StandardLogger()
+	}
+	if opts.Output == "" {
+		opts.Output = "."
+	}
+	if opts.MaxTweets <= 0 {
+		opts.MaxTweets = 3000
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.QuoteDepth < 1 {
+		opts.QuoteDepth = 1
+	}
+	if opts.MaxRetries < 1 {
+		opts.MaxRetries = 1
+	}
+	if opts.DateFormat == "" {
+		opts.DateFormat = "2006-01-02"
+	}
+	format := opts.FileFormat
+	if format == "" {
+		format = defaultFileFormat
+	}
+	name, err := compileNameTemplate(format)
+	if err != nil {
+		return nil, err
+	}
+	if !name.perMedia {
+		if name, err = compileNameTemplate(format + mediaSuffix); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Size == "large" {
+		opts.Size = "orig"
+	}
+	if !sizeRegex.MatchString(opts.Size) && opts.Size != "orig" {
+		opts.Logger.Error("Error in size, setting up to normal")
+		opts.Size = ""
+	}
+	if opts.VideoQuality == "" {
+		opts.VideoQuality = "best"
+	}
+	if !videoQualityRegex.MatchString(opts.VideoQuality) {
+		return nil, errors.New("video quality must be best, worst, <=HEIGHTp or <=BITRATE")
+	}
+	switch opts.GIFFormat {
+	case "":
+		opts.GIFFormat = "mp4"
+	case "mp4":
+	case "gif", "webp":
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return nil, fmt.Errorf("gif format %s needs ffmpeg to be installed", opts.GIFFormat)
+		}
+	default:
+		return nil, errors.New("gif format must be mp4, gif or webp")
+	}
+	switch opts.SearchTab {
+	case "":
+		opts.SearchTab = "top"
+	case "top", "latest", "media":
+	default:
+		return nil, errors.New("search tab must be top, latest or media")
+	}
+	switch opts.Orientation {
+	case "", "portrait", "landscape", "square":
+	default:
+		return nil, errors.New("orientation must be portrait, landscape or square")
+	}
+	filter, err := newTweetFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Downloader{
+		opts:            opts,
+		log:             opts.Logger,
+		retryStatus:     map[int]bool{},
+		filter:          filter,
+		name:            name,
+		archives:        map[string]*downloadArchive{},
+		mediaDetails:    map[string][]mediaDetail{},
+		quotes:          map[string]*quoteEntry{},
+		sleep:           sleepContext,
+		now:             time.Now,
+		ctx:             context.Background(),
+		lastMinuteReset: time.Now(),
+		batchPauseCount: 50,
+	}
+	filter.mediaDetails = d.fetchMediaDetails
+	for _, code := range opts.RetryStatus {
+		d.retryStatus[code] = true
+	}
+
+	d.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: time.Duration(5) * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   time.Duration(5) * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+			DisableKeepAlives:     true,
+		},
+	}
+	if opts.Proxy != "" {
+		proxyURL, err := URL.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		d.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyURL(proxyURL),
+			},
+		}
+	}
+
+	scraper := twitterscraper.New()
+	scraper.WithReplies(true)
+	if err := scraper.SetProxy(opts.Proxy); err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	d.scraper = scraperBackend{scraper}
+	return d, nil
+}
+
+// SetCookies authenticates the scraper with browser cookies.
+func (d *Downloader) SetCookies(cookies []*http.Cookie) {
+	d.scraper.SetCookies(cookies)
+}
+
+// SetAuthToken authenticates the scraper with the auth_token and ct0 cookies.
+func (d *Downloader) SetAuthToken(authToken, ct0 string) {
+	d.scraper.SetAuthToken(twitterscraper.AuthToken{Token: authToken, CSRFToken: ct0})
+}
+
+// Cookies returns the cookies of the scraper session.
+func (d *Downloader) Cookies() []*http.Cookie {
+	return d.scraper.GetCookies()
+}
+
+// IsLoggedIn checks the scraper session.
+func (d *Downloader) IsLoggedIn() bool {
+	return d.scraper.IsLoggedIn()
+}
+
+// start begins a download. The context of the download is kept for the
+// requests and waits made on its behalf.
+func (d *Downloader) start(ctx context.Context) *Result {
+	d.run.Lock()
+	d.ctx = ctx
+	d.result = &Result{}
+	d.counter = 0
+	d.startWorkers()
+	return d.result
+}
+
+func (d *Downloader) finish() {
+	d.stopWorkers()
+	d.ctx = context.Background()
+	d.result = nil
+	d.run.Unlock()
+}
+
+// DownloadUser crawls the timeline of a user and downloads its media in
+// Output/USERNAME. When ctx is cancelled, the crawl stops, the downloads in
+// progress are aborted and ctx.Err() is returned with the partial result.
+func (d *Downloader) DownloadUser(ctx context.Context, name string) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	return result, d.downloadTimeline(ctx, d.userTimeline(name))
+}
+
+// userTimeline is the timeline crawled by DownloadUser.
+func (d *Downloader) userTimeline(name string) timeline {
+	fetch := d.scraper.FetchTweets
+	if d.opts.MediaTweetsOnly {
+		fetch = d.scraper.FetchMediaTweets
+	}
+	return timeline{
+		name:    name,
+		query:   name,
+		output:  d.opts.Output + "/" + name,
+		fetch:   fetch,
+		ordered: true,
+		profile: true,
+	}
+}
+
+// DownloadLikes crawls the tweets liked by a user and downloads their media
+// in Output/USERNAME/likes. The scraper must be logged in.
+func (d *Downloader) DownloadLikes(ctx context.Context, name string) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	var userID string
+	err := d.retry429(func() (err error) {
+		if err := d.waitForRateLimit(); err != nil {
+			return err
+		}
+		userID, err = d.scraper.GetUserIDByScreenName(name)
+		return err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		return result, fmt.Errorf("failed to get the id of %s: %w", name, err)
+	}
+	return result, d.downloadTimeline(ctx, timeline{
+		name:   name,
+		query:  userID,
+		output: d.opts.Output + "/" + name + "/likes",
+		fetch:  d.fetchLikes,
+	})
+}
+
+// DownloadBookmarks crawls the bookmarks of the logged in account and
+// downloads their media in Output/bookmarks. The ids of the processed
+// bookmarks are kept in the state file; bookmarks are never removed.
+func (d *Downloader) DownloadBookmarks(ctx context.Context) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	return result, d.downloadTimeline(ctx, timeline{
+		name:   "bookmarks",
+		output: d.opts.Output + "/bookmarks",
+		fetch: func(_ string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+			return d.scraper.FetchBookmarks(maxTweetsNbr, cursor)
+		},
+		recordIDs: true,
+	})
+}
+
+// timeline describes a crawl of downloadTimeline.
+type timeline struct {
+	name   string // used in the logs and the state file
+	query  string // passed to fetch
+	output string
+	fetch  fetchTweetFunc
+	// ordered is set for timelines ordered by tweet id, where SinceID can
+	// be used.
+	ordered bool
+	// recordIDs keeps the ids of the processed tweets in the state file.
+	recordIDs bool
+	// profile is set for user timelines, whose profile is saved with
+	// ProfileAssets.
+	profile bool
+}
+
+// downloadTimeline crawls a timeline and downloads its media in its output
+// directory.
+func (d *Downloader) downloadTimeline(ctx context.Context, tl timeline) error {
+	output := tl.output
+	if d.opts.Videos {
+		os.MkdirAll(output+"/video", os.ModePerm)
+	}
+	if d.opts.Images {
+		os.MkdirAll(output+"/img", os.ModePerm)
+	}
+	if d.opts.GIFs {
+		os.MkdirAll(output+"/gif", os.ModePerm)
+	}
+	if _, err := d.archive(output); err != nil {
+		return err
+	}
+	if tl.profile && d.opts.ProfileAssets {
+		d.saveProfileAssets(tl.name, output)
+	}
+
+	statePath := output + "/twmd_state.json"
+	state, err := loadState(statePath)
+	if err != nil {
+		return fmt.Errorf("failed to read state file %s: %w", statePath, err)
+	}
+	state.User = tl.name
+	state.recordIDs = tl.recordIDs
+	if !d.opts.Resume {
+		state.Cursor = ""
+	} else if state.Cursor != "" {
+		d.log.Infof("Resuming %s after tweet %s", tl.name, state.LastTweetID)
+	}
+	bounds := crawlBounds{sinceID: d.opts.SinceID, since: d.opts.Since, until: d.opts.Until, ordered: tl.ordered}
+	if bounds.sinceID != "" && !tl.ordered {
+		d.log.Warnf("Ignoring --since-id, %s isn't ordered by tweet id", output)
+		bounds.sinceID = ""
+	}
+	if bounds.sinceID == "last" {
+		bounds.sinceID = state.NewestTweetID
+		if bounds.sinceID == "" {
+			d.log.Warn("No previous crawl recorded, ignoring --since-id last")
+		}
+	}
+
+	return d.crawlTimeline(ctx, tl.query, d.opts.MaxTweets, bounds, tl.fetch, state, statePath, func(wg *sync.WaitGroup, tweet *twitterscraper.TweetResult) {
+		if d.opts.Videos {
+			wg.Add(1)
+			go d.videoUser(wg, tweet, output, d.opts.Retweets)
+		}
+		if d.opts.Images {
+			wg.Add(1)
+			go d.photoUser(wg, tweet, output, d.opts.Retweets)
+		}
+		if d.opts.GIFs {
+			wg.Add(1)
+			go d.gifUser(wg, tweet, output, d.opts.Retweets)
+		}
+		if d.opts.FollowQuotes && tweet.QuotedStatusID != "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.followQuote(&tweet.Tweet, output, 1)
+			}()
+		}
+	})
+}
+
+// DownloadTweet downloads all the media of a single tweet in Output.
+func (d *Downloader) DownloadTweet(ctx context.Context, id string) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	output := d.opts.Output
+	os.MkdirAll(output, os.ModePerm)
+	if _, err := d.archive(output); err != nil {
+		return result, err
+	}
+	result.Tweets = 1
+	return result, d.singleTweet(output, id)
+}
+
+// DownloadTweetMedia downloads a single media of a tweet in Output: the
+// index-th photo for mediaType "photo", or the index-th video or gif for
+// "video", counting from 1 as in the links of the media.
+func (d *Downloader) DownloadTweetMedia(ctx context.Context, id string, mediaType string, index int) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	output := d.opts.Output
+	os.MkdirAll(output, os.ModePerm)
+	if _, err := d.archive(output); err != nil {
+		return result, err
+	}
+	if err := d.tweetMedia(output, id, mediaType, index); err != nil {
+		return result, err
+	}
+	result.Tweets = 1
+	return result, nil
+}
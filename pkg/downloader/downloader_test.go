@@ -0,0 +1,428 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// files lists the names in dir matching pattern.
+func files(t *testing.T, dir string, pattern string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range matches {
+		names = append(names, filepath.Base(m))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func allOptions() Options {
+	opts := DefaultOptions()
+	opts.Images = true
+	opts.Videos = true
+	opts.GIFs = true
+	return opts
+}
+
+func TestDownloadUser(t *testing.T) {
+	d, fake, media := newTestDownloader(t, allOptions())
+	result, err := d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user")
+
+	if result.Tweets != 4 {
+		t.Errorf("Tweets = %d, want 4", result.Tweets)
+	}
+	if len(result.Failed) != 0 {
+		t.Errorf("Failed = %v", result.Failed)
+	}
+
+	imgs := files(t, output+"/img", "*")
+	if len(imgs) != 2 || !strings.Contains(imgs[0], "_photo_a_Two photos.jpg") || !strings.Contains(imgs[1], "_photo_b_Two photos.jpg") {
+		t.Errorf("img = %v", imgs)
+	}
+	if rt := files(t, output+"/img", "RE-*"); len(rt) != 0 {
+		t.Errorf("retweets downloaded without Retweets: %v", rt)
+	}
+
+	// The best variant is downloaded, next to its sidecar files.
+	for _, ext := range []string{".mp4", ".jpg", ".nfo", ".ass", ".json"} {
+		if got := files(t, output+"/video", "*_video_720_A video"+ext); len(got) != 1 {
+			t.Errorf("video/*%s = %v", ext, got)
+		}
+	}
+	if media.count("/ext_tw_video/2021/pu/vid/720x1280/video_720.mp4") != 1 {
+		t.Error("best variant not downloaded")
+	}
+	if media.count("/ext_tw_video/2021/pu/vid/480x852/video_480.mp4") != 0 {
+		t.Error("other variants downloaded")
+	}
+
+	if got := files(t, output+"/gif", "*_gif_A gif.mp4"); len(got) != 1 {
+		t.Errorf("gif = %v", files(t, output+"/gif", "*"))
+	}
+
+	if len(result.Downloaded) != 4 {
+		t.Errorf("Downloaded %d media, want 4", len(result.Downloaded))
+	}
+	for _, m := range result.Downloaded {
+		js, err := os.ReadFile(m.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(js) != mediaContent(strings.Split(strings.TrimPrefix(m.URL, media.URL), "?")[0]) || int64(len(js)) != m.Size {
+			t.Errorf("wrong content in %s", m.Path)
+		}
+	}
+
+	state, err := loadState(output + "/twmd_state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.NewestTweetID != "1003" || state.LastTweetID != "1000" {
+		t.Errorf("state = %+v", state)
+	}
+	if fake.requests[0] != "FetchTweets fixture_user " {
+		t.Errorf("first request = %q", fake.requests[0])
+	}
+}
+
+func TestDownloadUserTweetJSON(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Videos = true
+	d, _, _ := newTestDownloader(t, opts)
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user", "video")
+	names := files(t, output, "*.json")
+	if len(names) != 1 {
+		t.Fatalf("json = %v", names)
+	}
+	js, err := os.ReadFile(filepath.Join(output, names[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		ID      string
+		Variant videoVariant
+	}
+	if err := json.Unmarshal(js, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.ID != "1002" || saved.Variant.Width != 720 || saved.Variant.Height != 1280 || saved.Variant.Bitrate != 2176000 {
+		t.Errorf("saved = %+v", saved)
+	}
+	if !strings.HasSuffix(saved.Variant.HLS, "/ext_tw_video/2021/pu/pl/video.m3u8") {
+		t.Errorf("HLS = %q", saved.Variant.HLS)
+	}
+}
+
+func TestDownloadUserRetweets(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Images = true
+	opts.RetweetsOnly = true
+	d, fake, _ := newTestDownloader(t, opts)
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user", "img")
+	if got := files(t, output, "*"); len(got) != 1 || !strings.HasPrefix(got[0], "RE-") {
+		t.Errorf("img = %v", got)
+	}
+	found := false
+	for _, r := range fake.requests {
+		found = found || r == "GetTweet 1001"
+	}
+	if !found {
+		t.Error("retweet not fetched")
+	}
+}
+
+func TestDownloadUserUpdate(t *testing.T) {
+	opts := allOptions()
+	opts.Update = true
+	d, _, media := newTestDownloader(t, opts)
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	requests := media.total()
+
+	// The archive doesn't depend on the names of the files.
+	d.opts.FileFormat = "{ID}"
+	result, err := d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Downloaded) != 0 {
+		t.Errorf("downloaded again: %v", result.Downloaded)
+	}
+	if media.total() != requests {
+		t.Errorf("%d media requests on update", media.total()-requests)
+	}
+}
+
+func TestDownloadUserConvertedGIF(t *testing.T) {
+	// A fake ffmpeg copies the MP4 source to the converted file.
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\nwhile [ \"$1\" != -i ]; do shift; done\ncp \"$2\" \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	opts := DefaultOptions()
+	opts.GIFs = true
+	opts.GIFFormat = "gif"
+	opts.Update = true
+	d, _, media := newTestDownloader(t, opts)
+	result, err := d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user")
+	gifs := files(t, output+"/gif", "*")
+	if len(gifs) != 1 || !strings.HasSuffix(gifs[0], ".gif") {
+		t.Fatalf("gif = %v", gifs)
+	}
+	path := filepath.Join(output, "gif", gifs[0])
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Downloaded) != 1 || result.Downloaded[0].Path != path {
+		t.Errorf("Downloaded = %v", result.Downloaded)
+	}
+
+	// The archive records the converted file.
+	a, err := openArchive(output+"/twmd_archive.jsonl", d.log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range a.entries {
+		if e.Path != path || e.Size != info.Size() {
+			t.Errorf("archive entry = %+v", e)
+		}
+	}
+
+	// Without the archive, the converted file is found on disk.
+	os.Remove(output + "/twmd_archive.jsonl")
+	d.archives = map[string]*downloadArchive{}
+	requests := media.total()
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	if media.total() != requests {
+		t.Errorf("%d media requests on update", media.total()-requests)
+	}
+}
+
+func TestDownloadUserSinceID(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Images = true
+	opts.SinceID = "1002"
+	d, fake, _ := newTestDownloader(t, opts)
+	result, err := d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tweets != 1 || len(result.Downloaded) != 2 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("requests = %v", fake.requests)
+	}
+}
+
+func TestDownloadUserDateRange(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Images = true
+	opts.Since = time.Unix(1710000250, 0)
+	d, fake, _ := newTestDownloader(t, opts)
+	result, err := d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The crawl stops at 1002, the first tweet older than Since.
+	if result.Tweets != 1 || len(result.Downloaded) != 2 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("requests = %v", fake.requests)
+	}
+
+	opts = DefaultOptions()
+	opts.GIFs = true
+	opts.Until = time.Unix(1710000300, 0)
+	d, fake, _ = newTestDownloader(t, opts)
+	result, err = d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1003 is skipped, the crawl goes on.
+	if result.Tweets != 3 || len(result.Downloaded) != 1 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("requests = %v", fake.requests)
+	}
+
+	// Bookmarks aren't ordered, older tweets are skipped without stopping.
+	opts = DefaultOptions()
+	opts.Images = true
+	opts.Since = time.Unix(1710000250, 0)
+	d, fake, _ = newTestDownloader(t, opts)
+	d.SetAuthToken("token", "ct0")
+	result, err = d.DownloadBookmarks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tweets != 1 || len(fake.requests) != 2 {
+		t.Errorf("Tweets = %d, requests = %v", result.Tweets, fake.requests)
+	}
+}
+
+func TestDownloadUserResume(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Images = true
+	opts.Resume = true
+	d, fake, _ := newTestDownloader(t, opts)
+	output := filepath.Join(d.opts.Output, "fixture_user")
+	os.MkdirAll(output, os.ModePerm)
+	state := &crawlState{User: "fixture_user", Cursor: "2", LastTweetID: "1002"}
+	if err := state.save(output + "/twmd_state.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.requests[0] != "FetchTweets fixture_user 2" {
+		t.Errorf("first request = %q", fake.requests[0])
+	}
+	if result.Tweets != 2 {
+		t.Errorf("Tweets = %d, want 2", result.Tweets)
+	}
+}
+
+func TestDownloadTweet(t *testing.T) {
+	d, _, _ := newTestDownloader(t, DefaultOptions())
+	result, err := d.DownloadTweet(context.Background(), "1003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, d.opts.Output, "*.jpg"); len(got) != 2 {
+		t.Errorf("files = %v", got)
+	}
+	if len(result.Downloaded) != 2 {
+		t.Errorf("Downloaded = %v", result.Downloaded)
+	}
+
+	if _, err := d.DownloadTweet(context.Background(), "1"); err == nil {
+		t.Error("DownloadTweet of an unknown tweet should fail")
+	}
+}
+
+func TestDownloadUserCancel(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Images = true
+	d, _, media := newTestDownloader(t, opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Interrupt the run while the first photo is being transferred.
+	media.hook = func(r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "photo_a.jpg") {
+			cancel()
+			<-r.Context().Done()
+		}
+	}
+
+	result, err := d.DownloadUser(ctx, "fixture_user")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v", err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user")
+	if got := files(t, output+"/img", "*photo_a*.jpg"); len(got) != 0 {
+		t.Errorf("interrupted download renamed: %v", got)
+	}
+	if len(result.Failed) != 0 {
+		t.Errorf("interrupted downloads reported as failures: %v", result.Failed)
+	}
+	// The interrupted page isn't checkpointed.
+	if state, _ := loadState(output + "/twmd_state.json"); state.NewestTweetID != "" || state.Cursor != "" {
+		t.Errorf("state = %+v", state)
+	}
+}
+
+func TestDownloadTweetCancel(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, DefaultOptions())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.DownloadTweet(ctx, "1003"); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("requests = %v", fake.requests)
+	}
+}
+
+func TestDownloadBookmarks(t *testing.T) {
+	opts := allOptions()
+	opts.SinceID = "1001"
+	d, fake, _ := newTestDownloader(t, opts)
+	if _, err := d.DownloadBookmarks(context.Background()); err == nil {
+		t.Error("DownloadBookmarks should fail when logged out")
+	}
+
+	d.SetAuthToken("token", "ct0")
+	result, err := d.DownloadBookmarks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "bookmarks")
+	// SinceID is ignored, bookmarks aren't ordered by tweet id.
+	if result.Tweets != 3 || len(result.Downloaded) != 4 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	for _, ext := range []string{".mp4", ".nfo", ".ass", ".json"} {
+		if got := files(t, output+"/video", "*_video_720_A video"+ext); len(got) != 1 {
+			t.Errorf("video/*%s = %v", ext, got)
+		}
+	}
+	if got := files(t, output+"/gif", "*"); len(got) != 1 {
+		t.Errorf("gif = %v", got)
+	}
+
+	state, err := loadState(output + "/twmd_state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(state.ProcessedIDs, ","); got != "1000,1003,1002" {
+		t.Errorf("processed = %s", got)
+	}
+
+	// Bookmarks processed again aren't recorded twice.
+	fake.bookmarks = append(fake.bookmarks, fake.tweets["1001"])
+	if _, err := d.DownloadBookmarks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	state, _ = loadState(output + "/twmd_state.json")
+	if got := strings.Join(state.ProcessedIDs, ","); got != "1000,1003,1002,1001" {
+		t.Errorf("processed = %s", got)
+	}
+}
@@ -0,0 +1,521 @@
+package downloader
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// filterExpr is a compiled Filter expression, a condition on the fields of a
+// tweet such as
+//
+//	(duration > 30s and is_reply and likes > 1000) or hashtags contains "launch"
+//
+// Conditions are combined with and, or, not and parentheses. Numbers and
+// dates are compared with == != < <= > >=, strings with == != contains and
+// ~ (matches a regex), and hashtags with contains or in.
+type filterExpr struct {
+	eval func(*exprEnv) bool
+}
+
+// exprEnv is the tweet an expression is evaluated on.
+type exprEnv struct {
+	tweet *twitterscraper.Tweet
+	// content is the retweeted tweet for retweets, tweet otherwise.
+	content  *twitterscraper.Tweet
+	duration func() float64
+}
+
+type exprType int
+
+const (
+	exprBool exprType = iota
+	exprNumber
+	exprString
+	exprList
+	exprDate
+)
+
+func (t exprType) String() string {
+	return [...]string{"a condition", "a number", "a string", "a list", "a date"}[t]
+}
+
+// exprOperand is a typed value of an expression. Only the function of its
+// type is set.
+type exprOperand struct {
+	typ     exprType
+	pos     int
+	boolean func(*exprEnv) bool
+	number  func(*exprEnv) float64
+	str     func(*exprEnv) string
+	list    func(*exprEnv) []string
+	date    func(*exprEnv) time.Time
+	// literal is set for string literals, day for dates without a time.
+	literal *string
+	day     bool
+	// seconds is set for the duration field, inSeconds for number literals
+	// with a s, m or h unit, which can only be compared with it.
+	seconds   bool
+	inSeconds bool
+}
+
+// exprFields are the fields of a tweet usable in an expression.
+var exprFields = map[string]exprOperand{
+	"likes":        {typ: exprNumber, number: func(e *exprEnv) float64 { return float64(e.content.Likes) }},
+	"retweets":     {typ: exprNumber, number: func(e *exprEnv) float64 { return float64(e.content.Retweets) }},
+	"replies":      {typ: exprNumber, number: func(e *exprEnv) float64 { return float64(e.content.Replies) }},
+	"views":        {typ: exprNumber, number: func(e *exprEnv) float64 { return float64(e.content.Views) }},
+	"media_count":  {typ: exprNumber, number: func(e *exprEnv) float64 { return float64(mediaCount(e.content)) }},
+	"duration":     {typ: exprNumber, seconds: true, number: func(e *exprEnv) float64 { return e.duration() }},
+	"text":         {typ: exprString, str: func(e *exprEnv) string { return e.content.Text }},
+	"username":     {typ: exprString, str: func(e *exprEnv) string { return e.content.Username }},
+	"hashtags":     {typ: exprList, list: func(e *exprEnv) []string { return tweetHashtags(e.content) }},
+	"is_retweet":   {typ: exprBool, boolean: func(e *exprEnv) bool { return e.tweet.IsRetweet }},
+	"is_reply":     {typ: exprBool, boolean: func(e *exprEnv) bool { return e.content.IsReply }},
+	"is_quote":     {typ: exprBool, boolean: func(e *exprEnv) bool { return e.content.QuotedStatusID != "" }},
+	"is_sensitive": {typ: exprBool, boolean: func(e *exprEnv) bool { return e.content.SensitiveContent }},
+	"date":         {typ: exprDate, date: func(e *exprEnv) time.Time { return time.Unix(e.tweet.Timestamp, 0) }},
+}
+
+// mediaCount counts the photos, videos and gifs of a tweet.
+func mediaCount(tweet *twitterscraper.Tweet) int {
+	n := len(tweet.Videos) + len(tweet.GIFs)
+	for _, p := range tweet.Photos {
+		if !strings.Contains(p.URL, "video_thumb/") {
+			n++
+		}
+	}
+	return n
+}
+
+// tweetHashtags returns the lowercase hashtags of a tweet, from its entities
+// and its text.
+func tweetHashtags(tweet *twitterscraper.Tweet) []string {
+	var tags []string
+	seen := map[string]bool{}
+	add := func(tag string) {
+		if tag = strings.ToLower(tag); !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	for _, tag := range tweet.Hashtags {
+		add(tag)
+	}
+	for _, m := range hashtagRegex.FindAllStringSubmatch(tweet.Text, -1) {
+		add(m[1])
+	}
+	return tags
+}
+
+type exprToken struct {
+	kind    string // ident, number, date, string, op or eof
+	text    string
+	num     float64
+	seconds bool // number with a s, m or h unit
+	date    time.Time
+	day     bool
+	pos     int
+}
+
+var exprDateRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}(:\d{2})?)?`)
+
+func lexExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || isLetter(c):
+			start := i
+			for i < len(s) && (s[i] == '_' || isLetter(s[i]) || isDigit(s[i])) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: strings.ToLower(s[start:i]), pos: start})
+		case isDigit(c):
+			if m := exprDateRegex.FindString(s[i:]); m != "" {
+				layout := "2006-01-02T15:04:05"[:len(m)]
+				date, err := time.ParseInLocation(layout, m, time.Local)
+				if err != nil {
+					return nil, fmt.Errorf("invalid date %q at column %d", m, i+1)
+				}
+				tokens = append(tokens, exprToken{kind: "date", text: m, date: date, day: len(m) == 10, pos: i})
+				i += len(m)
+				continue
+			}
+			start := i
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			num, err := strconv.ParseFloat(s[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at column %d", s[start:i], start+1)
+			}
+			// Durations are in seconds, 30s, 2m or 1h, and 1.5k is 1500.
+			seconds := false
+			if i < len(s) {
+				if unit, ok := map[byte]float64{'s': 1, 'm': 60, 'h': 3600, 'k': 1000}[s[i]]; ok {
+					num *= unit
+					seconds = s[i] != 'k'
+					i++
+				}
+			}
+			if i < len(s) && (s[i] == '_' || isLetter(s[i]) || isDigit(s[i])) {
+				return nil, fmt.Errorf("invalid number %q at column %d", s[start:i+1], start+1)
+			}
+			tokens = append(tokens, exprToken{kind: "number", text: s[start:i], num: num, seconds: seconds, pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			var b strings.Builder
+			i++
+			for i < len(s) && s[i] != c {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated string at column %d", start+1)
+			}
+			i++
+			tokens = append(tokens, exprToken{kind: "string", text: b.String(), pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "!~", "<", ">", "!", "~", "(", ")"} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				if c == '=' {
+					return nil, fmt.Errorf("unexpected = at column %d, use == to compare", i+1)
+				}
+				return nil, fmt.Errorf("unexpected %q at column %d", c, i+1)
+			}
+			tokens = append(tokens, exprToken{kind: "op", text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{kind: "eof", pos: len(s)}), nil
+}
+
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+type exprParser struct {
+	tokens []exprToken
+	i      int
+}
+
+// compileFilter parses a Filter expression. Errors give the column of the
+// problem.
+func compileFilter(s string) (*filterExpr, error) {
+	tokens, err := lexExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, fmt.Errorf("unexpected %s at column %d", describe(t), t.pos+1)
+	}
+	if root.typ != exprBool {
+		return nil, fmt.Errorf("the filter is %s, not a condition", root.typ)
+	}
+	return &filterExpr{eval: root.boolean}, nil
+}
+
+func (p *exprParser) peek() exprToken { return p.tokens[p.i] }
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.i]
+	if t.kind != "eof" {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token when it is one of words, keywords or
+// operators.
+func (p *exprParser) accept(words ...string) (exprToken, bool) {
+	t := p.peek()
+	if t.kind != "ident" && t.kind != "op" {
+		return t, false
+	}
+	for _, w := range words {
+		if t.text == w {
+			return p.next(), true
+		}
+	}
+	return t, false
+}
+
+func describe(t exprToken) string {
+	switch t.kind {
+	case "eof":
+		return "end of filter"
+	case "string":
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+func (p *exprParser) parseOr() (exprOperand, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for {
+		op, ok := p.accept("or", "||")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		if err := needBool(op, left, right); err != nil {
+			return left, err
+		}
+		l, r := left.boolean, right.boolean
+		left = exprOperand{typ: exprBool, pos: left.pos, boolean: func(e *exprEnv) bool { return l(e) || r(e) }}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprOperand, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return left, err
+	}
+	for {
+		op, ok := p.accept("and", "&&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return right, err
+		}
+		if err := needBool(op, left, right); err != nil {
+			return left, err
+		}
+		l, r := left.boolean, right.boolean
+		left = exprOperand{typ: exprBool, pos: left.pos, boolean: func(e *exprEnv) bool { return l(e) && r(e) }}
+	}
+}
+
+func (p *exprParser) parseNot() (exprOperand, error) {
+	op, ok := p.accept("not", "!")
+	if !ok {
+		return p.parseComparison()
+	}
+	operand, err := p.parseNot()
+	if err != nil {
+		return operand, err
+	}
+	if err := needBool(op, operand); err != nil {
+		return operand, err
+	}
+	f := operand.boolean
+	return exprOperand{typ: exprBool, pos: op.pos, boolean: func(e *exprEnv) bool { return !f(e) }}, nil
+}
+
+func needBool(op exprToken, operands ...exprOperand) error {
+	for _, o := range operands {
+		if o.typ != exprBool {
+			return fmt.Errorf("'%s' needs conditions, got %s at column %d", op.text, o.typ, o.pos+1)
+		}
+	}
+	return nil
+}
+
+func (p *exprParser) parseComparison() (exprOperand, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return left, err
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "~", "!~", "contains", "in")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parsePrimary()
+	if err != nil {
+		return right, err
+	}
+	return compare(op, left, right)
+}
+
+func (p *exprParser) parsePrimary() (exprOperand, error) {
+	t := p.next()
+	switch t.kind {
+	case "number":
+		n := t.num
+		return exprOperand{typ: exprNumber, pos: t.pos, inSeconds: t.seconds, number: func(*exprEnv) float64 { return n }}, nil
+	case "date":
+		date := t.date
+		return exprOperand{typ: exprDate, pos: t.pos, day: t.day, date: func(*exprEnv) time.Time { return date }}, nil
+	case "string":
+		s := t.text
+		return exprOperand{typ: exprString, pos: t.pos, literal: &s, str: func(*exprEnv) string { return s }}, nil
+	case "op":
+		if t.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return inner, err
+			}
+			if closing := p.next(); closing.text != ")" || closing.kind != "op" {
+				return inner, fmt.Errorf("expected ')' at column %d, got %s", closing.pos+1, describe(closing))
+			}
+			return inner, nil
+		}
+	case "ident":
+		switch t.text {
+		case "and", "or", "not", "contains", "in":
+			return exprOperand{}, fmt.Errorf("expected a field or a value at column %d, got %s", t.pos+1, describe(t))
+		case "true", "false":
+			b := t.text == "true"
+			return exprOperand{typ: exprBool, pos: t.pos, boolean: func(*exprEnv) bool { return b }}, nil
+		}
+		field, ok := exprFields[t.text]
+		if !ok {
+			names := make([]string, 0, len(exprFields))
+			for name := range exprFields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return field, fmt.Errorf("unknown field %q at column %d, expected one of %s", t.text, t.pos+1, strings.Join(names, ", "))
+		}
+		field.pos = t.pos
+		return field, nil
+	}
+	return exprOperand{}, fmt.Errorf("expected a field or a value at column %d, got %s", t.pos+1, describe(t))
+}
+
+// compare builds the condition left op right.
+func compare(op exprToken, left, right exprOperand) (exprOperand, error) {
+	result := exprOperand{typ: exprBool, pos: left.pos}
+	mismatch := fmt.Errorf("can't use '%s' between %s and %s at column %d", op.text, left.typ, right.typ, op.pos+1)
+
+	switch op.text {
+	case "in", "contains":
+		if op.text == "in" {
+			left, right = right, left
+		}
+		if right.typ != exprString {
+			return result, mismatch
+		}
+		needle := right.str
+		switch left.typ {
+		case exprList:
+			list := left.list
+			result.boolean = func(e *exprEnv) bool {
+				tag := strings.ToLower(strings.TrimPrefix(needle(e), "#"))
+				for _, item := range list(e) {
+					if item == tag {
+						return true
+					}
+				}
+				return false
+			}
+		case exprString:
+			s := left.str
+			result.boolean = func(e *exprEnv) bool {
+				return strings.Contains(strings.ToLower(s(e)), strings.ToLower(needle(e)))
+			}
+		default:
+			return result, mismatch
+		}
+		return result, nil
+
+	case "~", "!~":
+		if left.typ != exprString || right.typ != exprString {
+			return result, mismatch
+		}
+		if right.literal == nil {
+			return result, fmt.Errorf("'%s' needs a regex string at column %d", op.text, right.pos+1)
+		}
+		re, err := regexp.Compile(*right.literal)
+		if err != nil {
+			return result, fmt.Errorf("invalid regex at column %d: %w", right.pos+1, err)
+		}
+		s, negate := left.str, op.text == "!~"
+		result.boolean = func(e *exprEnv) bool { return re.MatchString(s(e)) != negate }
+		return result, nil
+	}
+
+	if left.typ != right.typ {
+		return result, mismatch
+	}
+	var cmp func(e *exprEnv) int
+	switch left.typ {
+	case exprNumber:
+		// 1m is a minute, not a million: units are only for durations.
+		for _, pair := range [][2]exprOperand{{left, right}, {right, left}} {
+			if pair[0].inSeconds && !pair[1].seconds {
+				return result, fmt.Errorf("s, m and h units are only for duration at column %d, use k for thousands", pair[0].pos+1)
+			}
+		}
+		l, r := left.number, right.number
+		cmp = func(e *exprEnv) int {
+			a, b := l(e), r(e)
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	case exprDate:
+		l, r := left.date, right.date
+		if left.day || right.day {
+			// A day stands for all its tweets.
+			cmp = func(e *exprEnv) int {
+				return strings.Compare(l(e).Local().Format("2006-01-02"), r(e).Local().Format("2006-01-02"))
+			}
+		} else {
+			cmp = func(e *exprEnv) int { return l(e).Compare(r(e)) }
+		}
+	case exprString, exprBool:
+		if op.text != "==" && op.text != "!=" {
+			return result, mismatch
+		}
+		if left.typ == exprString {
+			l, r := left.str, right.str
+			cmp = func(e *exprEnv) int { return strings.Compare(l(e), r(e)) }
+		} else {
+			l, r := left.boolean, right.boolean
+			cmp = func(e *exprEnv) int {
+				if l(e) == r(e) {
+					return 0
+				}
+				return 1
+			}
+		}
+	default:
+		return result, mismatch
+	}
+
+	test := map[string]func(int) bool{
+		"==": func(c int) bool { return c == 0 },
+		"!=": func(c int) bool { return c != 0 },
+		"<":  func(c int) bool { return c < 0 },
+		"<=": func(c int) bool { return c <= 0 },
+		">":  func(c int) bool { return c > 0 },
+		">=": func(c int) bool { return c >= 0 },
+	}[op.text]
+	result.boolean = func(e *exprEnv) bool { return test(cmp(e)) }
+	return result, nil
+}
@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+func TestFilterExpr(t *testing.T) {
+	posted := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+	reply := &twitterscraper.Tweet{
+		ID:        "1",
+		Text:      "Liftoff! #Launch",
+		Likes:     1500,
+		Views:     90000,
+		IsReply:   true,
+		Timestamp: posted.Unix(),
+		Videos:    []twitterscraper.Video{{ID: "v1"}},
+	}
+	retweet := &twitterscraper.Tweet{ID: "2", IsRetweet: true, Timestamp: posted.Unix(), RetweetedStatus: &twitterscraper.Tweet{ID: "3", Likes: 10, Photos: []twitterscraper.Photo{{URL: "a.jpg"}, {URL: "b.jpg"}}}}
+
+	details := func(id string) ([]mediaDetail, error) {
+		var m mediaDetail
+		m.VideoInfo.DurationMillis = 45500
+		return []mediaDetail{m}, nil
+	}
+	tests := []struct {
+		expr  string
+		tweet *twitterscraper.Tweet
+		want  bool
+	}{
+		{`likes > 1000`, reply, true},
+		{`likes >= 1.5k and views < 100000`, reply, true},
+		{`(duration > 30s and is_reply and likes > 1000) or hashtags contains "launch"`, reply, true},
+		{`duration > 1m`, reply, false},
+		{`1m > duration`, reply, true},
+		{`duration > 45 && duration < 46`, reply, true},
+		{`"#launch" in hashtags`, reply, true},
+		{`text contains "LIFTOFF"`, reply, true},
+		{`text ~ "^Lift" and text !~ "landing"`, reply, true},
+		{`not is_reply || is_retweet`, reply, false},
+		{`!(media_count == 1)`, reply, false},
+		{`date >= 2024-03-15 and date <= 2024-03-15`, reply, true},
+		{`date < 2024-03-15T12:00`, reply, false},
+		{`is_retweet == true and likes < 100 and media_count == 2`, retweet, true},
+		{`duration == 0`, retweet, true},
+		{`is_quote or is_sensitive`, retweet, false},
+	}
+	for _, test := range tests {
+		expr, err := compileFilter(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		content := test.tweet
+		if content.RetweetedStatus != nil {
+			content = content.RetweetedStatus
+		}
+		f := &tweetFilter{mediaDetails: details}
+		env := &exprEnv{tweet: test.tweet, content: content, duration: f.durationFunc(content)}
+		if got := expr.eval(env); got != test.want {
+			t.Errorf("%s = %v, want %v", test.expr, got, test.want)
+		}
+	}
+
+	invalid := []struct{ expr, err string }{
+		{`lieks > 10`, `unknown field "lieks" at column 1`},
+		{`likes = 10`, `unexpected = at column 7, use == to compare`},
+		{`likes > "many"`, `can't use '>' between a number and a string at column 7`},
+		{`likes > 10 and`, `expected a field or a value at column 15, got end of filter`},
+		{`(likes > 10`, `expected ')' at column 12`},
+		{`likes`, `the filter is a number, not a condition`},
+		{`likes and is_reply`, `'and' needs conditions, got a number at column 1`},
+		{`text ~ "("`, `invalid regex at column 8`},
+		{`text contains 'x`, `unterminated string at column 15`},
+		{`likes > 10 10`, `unexpected '10' at column 12`},
+		{`duration > 30x`, `invalid number "30x" at column 12`},
+		{`views > 1m`, `s, m and h units are only for duration at column 9`},
+		{`30s < likes`, `s, m and h units are only for duration at column 1`},
+	}
+	for _, test := range invalid {
+		_, err := compileFilter(test.expr)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: err = %v, want %q", test.expr, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// statusError is returned for media requests answered with an unexpected
+// status code.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return "status code: " + strconv.Itoa(e.code)
+}
+
+// retryable reports whether a failed media download is worth retrying:
+// network errors and the status codes of RetryStatus are, file system
+// errors and other status codes are not.
+func (d *Downloader) retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return d.retryStatus[se.code]
+	}
+	var pe *fs.PathError
+	return !errors.As(err, &pe)
+}
+
+func (d *Downloader) recordFailure(tweetID, url, path string, err error) {
+	d.resultLock.Lock()
+	defer d.resultLock.Unlock()
+	if d.result != nil {
+		d.result.Failed = append(d.result.Failed, Failure{TweetID: tweetID, URL: url, Path: path, Err: err})
+	}
+}
+
+func (d *Downloader) recordDownload(tweetID, url, path string, size int64) {
+	d.resultLock.Lock()
+	defer d.resultLock.Unlock()
+	if d.result != nil {
+		d.result.Downloaded = append(d.result.Downloaded, Media{TweetID: tweetID, URL: url, Path: path, Size: size})
+	}
+}
+
+// fetchFile downloads url into path. The data is written to path+".part"
+// and renamed once complete, so an interrupted transfer never leaves a
+// truncated file behind. An existing .part file is resumed with a Range
+// request when the server supports it. It returns the size and the sha256
+// of the file.
+func (d *Downloader) fetchFile(url string, path string) (int64, string, error) {
+	part := path + ".part"
+	var offset int64
+	if fi, err := os.Stat(part); err == nil {
+		offset = fi.Size()
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, "GET", url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Add("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, total := parseContentRange(resp.Header.Get("Content-Range"))
+		if start != offset {
+			return 0, "", fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		if total >= 0 && resp.ContentLength >= 0 && offset+resp.ContentLength != total {
+			return 0, "", fmt.Errorf("Content-Range %q doesn't match Content-Length %d", resp.Header.Get("Content-Range"), resp.ContentLength)
+		}
+		d.log.Infof("Resuming download at %d bytes: %s", offset, path)
+		flags = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The .part file is stale or already larger than the media, start over.
+		os.Remove(part)
+		return d.fetchFile(url, path)
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	default:
+		return 0, "", &statusError{code: resp.StatusCode}
+	}
+
+	hash := sha256.New()
+	if offset > 0 {
+		// Hash the bytes already on disk so the checksum covers the whole file.
+		pf, err := os.Open(part)
+		if err != nil {
+			return 0, "", err
+		}
+		_, err = io.Copy(hash, io.LimitReader(pf, offset))
+		pf.Close()
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return 0, "", err
+	}
+	written, err := io.Copy(io.MultiWriter(f, hash), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return 0, "", fmt.Errorf("incomplete download: got %d of %d bytes", written, resp.ContentLength)
+	}
+
+	if err := os.Rename(part, path); err != nil {
+		return 0, "", err
+	}
+	return offset + written, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fetchWithRetry calls fetchFile until it succeeds, the error isn't
+// retryable or MaxRetries attempts were made. Final failures are recorded
+// for the end of run report. Interrupted downloads aren't failures: their
+// .part file is kept and resumed by the next run.
+func (d *Downloader) fetchWithRetry(tweetID string, url string, path string) (int64, string, error) {
+	for attempt := 0; ; attempt++ {
+		written, sum, err := d.fetchFile(url, path)
+		if err == nil {
+			return written, sum, nil
+		}
+		if d.ctx.Err() != nil {
+			return 0, "", d.ctx.Err()
+		}
+		if attempt+1 >= d.opts.MaxRetries || !d.retryable(err) {
+			d.recordFailure(tweetID, url, path, err)
+			return 0, "", err
+		}
+		waitTime := d.getRetryWaitTime(attempt)
+		d.log.Warnf("Download of %s failed: %s. Retrying in %v (attempt %d/%d)", url, err.Error(), waitTime, attempt+1, d.opts.MaxRetries)
+		if err := d.sleep(d.ctx, waitTime); err != nil {
+			return 0, "", err
+		}
+	}
+}
+
+// parseContentRange parses a "bytes start-end/total" header. total is -1
+// when unknown.
+func parseContentRange(header string) (int64, int64) {
+	var start, end int64
+	var total string
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		return -1, -1
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return start, -1
+	}
+	return start, size
+}
@@ -0,0 +1,154 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchRetry(t *testing.T) {
+	d, _, media := newTestDownloader(t, DefaultOptions())
+	media.fail("/retry.jpg", 503, 429)
+	path := filepath.Join(d.opts.Output, "retry.jpg")
+
+	d.start(context.Background())
+	defer d.finish()
+	written, _, err := d.fetchWithRetry("1", media.URL+"/retry.jpg", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.count("/retry.jpg") != 3 {
+		t.Errorf("%d requests, want 3", media.count("/retry.jpg"))
+	}
+	if written != int64(len(mediaContent("/retry.jpg"))) {
+		t.Errorf("written = %d", written)
+	}
+	if len(d.result.Failed) != 0 {
+		t.Errorf("Failed = %v", d.result.Failed)
+	}
+}
+
+func TestFetchFailure(t *testing.T) {
+	d, _, media := newTestDownloader(t, DefaultOptions())
+	media.fail("/gone.jpg", 404)
+	media.fail("/busy.jpg", 503, 503, 503)
+
+	d.start(context.Background())
+	defer d.finish()
+	for _, name := range []string{"gone.jpg", "busy.jpg"} {
+		path := filepath.Join(d.opts.Output, name)
+		if _, _, err := d.fetchWithRetry("1", media.URL+"/"+name, path); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("%s: file created", name)
+		}
+	}
+	// 404 isn't retried.
+	if media.count("/gone.jpg") != 1 || media.count("/busy.jpg") != 3 {
+		t.Errorf("requests: gone %d, busy %d", media.count("/gone.jpg"), media.count("/busy.jpg"))
+	}
+	if len(d.result.Failed) != 2 {
+		t.Errorf("Failed = %v", d.result.Failed)
+	}
+}
+
+func TestFetchResume(t *testing.T) {
+	d, _, media := newTestDownloader(t, DefaultOptions())
+	path := filepath.Join(d.opts.Output, "video.mp4")
+	content := mediaContent("/video.mp4")
+	if err := os.WriteFile(path+".part", []byte(content[:100]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	full, sum, err := d.fetchFile(media.URL+"/video.mp4", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if string(got) != content || full != int64(len(content)) {
+		t.Errorf("resumed file differs from the media")
+	}
+	if r := media.ranges["/video.mp4"]; len(r) != 1 || r[0] != "bytes=100-" {
+		t.Errorf("ranges = %v", r)
+	}
+	if _, err := os.Stat(path + ".part"); err == nil {
+		t.Error(".part file left behind")
+	}
+
+	// The checksum covers the whole file.
+	os.Remove(path)
+	_, want, err := d.fetchFile(media.URL+"/video.mp4", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != want {
+		t.Errorf("sha256 = %s, want %s", sum, want)
+	}
+}
+
+func TestFetchStalePart(t *testing.T) {
+	d, _, media := newTestDownloader(t, DefaultOptions())
+	path := filepath.Join(d.opts.Output, "photo.jpg")
+	content := mediaContent("/photo.jpg")
+	if err := os.WriteFile(path+".part", []byte(content+"garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.fetchFile(media.URL+"/photo.jpg", path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Error("stale .part file not replaced")
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for header, want := range map[string][2]int64{
+		"bytes 100-199/200": {100, 200},
+		"bytes 0-99/*":      {0, -1},
+		"invalid":           {-1, -1},
+	} {
+		start, total := parseContentRange(header)
+		if start != want[0] || total != want[1] {
+			t.Errorf("parseContentRange(%q) = %d, %d", header, start, total)
+		}
+	}
+}
+
+func TestRetry429(t *testing.T) {
+	d, _, _ := newTestDownloader(t, DefaultOptions())
+	d.start(context.Background())
+	defer d.finish()
+
+	attempts := 0
+	err := d.retry429(func() error {
+		attempts++
+		return errors.New("response status 429 Too Many Requests")
+	})
+	if err == nil || attempts != d.opts.MaxRetries {
+		t.Errorf("err = %v after %d attempts, want a 429 after %d", err, attempts, d.opts.MaxRetries)
+	}
+
+	attempts = 0
+	err = d.retry429(func() error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("response status 429 Too Many Requests")
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("err = %v after %d attempts, want nil after 2", err, attempts)
+	}
+
+	attempts = 0
+	err = d.retry429(func() error {
+		attempts++
+		return errors.New("not found")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("err = %v after %d attempts, want an error after 1", err, attempts)
+	}
+}
@@ -0,0 +1,225 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// tweetFilter selects the tweets of a timeline whose media are downloaded,
+// before any media request. It is built by New from the Options.
+type tweetFilter struct {
+	minLikes       int
+	minRetweets    int
+	minViews       int
+	include        *regexp.Regexp
+	exclude        *regexp.Regexp
+	hashtags       map[string]bool
+	excludeReplies bool
+	onlySensitive  bool
+	expr           *filterExpr
+
+	// mediaDetails gives the duration of videos to expr.
+	mediaDetails func(id string) ([]mediaDetail, error)
+}
+
+var hashtagRegex = regexp.MustCompile(`#(\w+)`)
+
+func newTweetFilter(opts Options) (*tweetFilter, error) {
+	f := &tweetFilter{
+		minLikes:       opts.MinLikes,
+		minRetweets:    opts.MinRetweets,
+		minViews:       opts.MinViews,
+		excludeReplies: opts.ExcludeReplies,
+		onlySensitive:  opts.OnlySensitive,
+	}
+	var err error
+	if opts.IncludeRegex != "" {
+		if f.include, err = regexp.Compile(opts.IncludeRegex); err != nil {
+			return nil, fmt.Errorf("invalid include regex: %w", err)
+		}
+	}
+	if opts.ExcludeRegex != "" {
+		if f.exclude, err = regexp.Compile(opts.ExcludeRegex); err != nil {
+			return nil, fmt.Errorf("invalid exclude regex: %w", err)
+		}
+	}
+	if strings.TrimSpace(opts.Filter) != "" {
+		if f.expr, err = compileFilter(opts.Filter); err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+	for _, tag := range opts.Hashtags {
+		if tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#")); tag != "" {
+			if f.hashtags == nil {
+				f.hashtags = map[string]bool{}
+			}
+			f.hashtags[tag] = true
+		}
+	}
+	return f, nil
+}
+
+// match reports whether tweet passes the filter, or why it doesn't. The
+// counts, text and hashtags of a retweet are those of the retweeted tweet.
+func (f *tweetFilter) match(tweet *twitterscraper.Tweet) (bool, string) {
+	timelineTweet := tweet
+	if tweet.IsRetweet && tweet.RetweetedStatus != nil {
+		tweet = tweet.RetweetedStatus
+	}
+	switch {
+	case tweet.Likes < f.minLikes:
+		return false, fmt.Sprintf("%d likes", tweet.Likes)
+	case tweet.Retweets < f.minRetweets:
+		return false, fmt.Sprintf("%d retweets", tweet.Retweets)
+	case tweet.Views < f.minViews:
+		return false, fmt.Sprintf("%d views", tweet.Views)
+	case f.excludeReplies && tweet.IsReply:
+		return false, "reply"
+	case f.onlySensitive && !tweet.SensitiveContent:
+		return false, "not sensitive"
+	case f.include != nil && !f.include.MatchString(tweet.Text):
+		return false, "text not included"
+	case f.exclude != nil && f.exclude.MatchString(tweet.Text):
+		return false, "text excluded"
+	}
+	if f.hashtags != nil && !f.hasHashtag(tweet) {
+		return false, "no hashtag"
+	}
+	if f.expr != nil && !f.expr.eval(&exprEnv{tweet: timelineTweet, content: tweet, duration: f.durationFunc(tweet)}) {
+		return false, "filter"
+	}
+	return true, ""
+}
+
+// durationFunc returns the duration in seconds of the longest video or gif
+// of tweet, fetching the media details once on first use.
+func (f *tweetFilter) durationFunc(tweet *twitterscraper.Tweet) func() float64 {
+	var duration float64
+	fetched := false
+	return func() float64 {
+		if fetched || len(tweet.Videos)+len(tweet.GIFs) == 0 || f.mediaDetails == nil {
+			return duration
+		}
+		fetched = true
+		media, err := f.mediaDetails(tweet.ID)
+		if err != nil {
+			return duration
+		}
+		for _, m := range media {
+			if seconds := float64(m.VideoInfo.DurationMillis) / 1000; seconds > duration {
+				duration = seconds
+			}
+		}
+		return duration
+	}
+}
+
+// hasHashtag reports whether tweet has one of the hashtags of the filter,
+// from its entities or its text.
+func (f *tweetFilter) hasHashtag(tweet *twitterscraper.Tweet) bool {
+	for _, tag := range tweet.Hashtags {
+		if f.hashtags[strings.ToLower(tag)] {
+			return true
+		}
+	}
+	for _, m := range hashtagRegex.FindAllStringSubmatch(tweet.Text, -1) {
+		if f.hashtags[strings.ToLower(m[1])] {
+			return true
+		}
+	}
+	return false
+}
+
+// keepMedia checks a photo, video or gif against the media filters, with the
+// metadata of the tweet payload and a HEAD request for MaxFileSize. Media
+// whose metadata can't be found are kept.
+func (d *Downloader) keepMedia(tweetID string, mediaID string, url string, kind string) bool {
+	if ok, reason := d.matchMedia(tweetID, mediaID, url, kind); !ok {
+		d.log.Infof("Skipping %s %s of tweet %s: %s", kind, mediaID, tweetID, reason)
+		return false
+	}
+	return true
+}
+
+func (d *Downloader) matchMedia(tweetID string, mediaID string, url string, kind string) (bool, string) {
+	opts := d.opts
+	dimensions := opts.MinWidth > 0 || opts.MinHeight > 0 || opts.Orientation != ""
+	durations := kind != "photo" && (opts.MinDuration > 0 || opts.MaxDuration > 0)
+	if dimensions || durations {
+		width, height, duration := d.mediaInfo(tweetID, mediaID, url)
+		if dimensions && width > 0 && height > 0 {
+			switch {
+			case width < opts.MinWidth || height < opts.MinHeight:
+				return false, fmt.Sprintf("%dx%d", width, height)
+			case opts.Orientation != "" && orientation(width, height) != opts.Orientation:
+				return false, fmt.Sprintf("%dx%d is %s", width, height, orientation(width, height))
+			}
+		}
+		if durations && duration > 0 {
+			switch {
+			case duration < opts.MinDuration:
+				return false, fmt.Sprintf("%v long", duration)
+			case opts.MaxDuration > 0 && duration > opts.MaxDuration:
+				return false, fmt.Sprintf("%v long", duration)
+			}
+		}
+	}
+	if opts.MaxFileSize > 0 {
+		if size := d.remoteSize(url); size > opts.MaxFileSize {
+			return false, fmt.Sprintf("%d bytes", size)
+		}
+	}
+	return true, ""
+}
+
+// mediaInfo returns the original resolution and the duration of a media,
+// zero when unknown.
+func (d *Downloader) mediaInfo(tweetID string, mediaID string, url string) (int, int, time.Duration) {
+	media, err := d.fetchMediaDetails(tweetID)
+	if err != nil && d.ctx.Err() == nil {
+		d.log.Warnf("Failed to get the metadata of the media of %s: %s", tweetID, err.Error())
+	}
+	for _, m := range media {
+		if m.IDStr == mediaID {
+			return m.OriginalInfo.Width, m.OriginalInfo.Height, time.Duration(m.VideoInfo.DurationMillis) * time.Millisecond
+		}
+	}
+	width, height := resolutionFromURL(url)
+	return width, height, 0
+}
+
+func orientation(width, height int) string {
+	switch {
+	case width > height:
+		return "landscape"
+	case width < height:
+		return "portrait"
+	}
+	return "square"
+}
+
+// remoteSize returns the size of url from a HEAD request, -1 when unknown.
+func (d *Downloader) remoteSize(url string) int64 {
+	req, err := http.NewRequestWithContext(d.ctx, "HEAD", url, nil)
+	if err != nil {
+		return -1
+	}
+	req.Header.Add("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		if d.ctx.Err() == nil {
+			d.log.Warnf("Failed to get the size of %s: %s", url, err.Error())
+		}
+		return -1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1
+	}
+	return resp.ContentLength
+}
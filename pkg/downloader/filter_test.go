@@ -0,0 +1,136 @@
+package downloader
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+func TestTweetFilter(t *testing.T) {
+	tweet := &twitterscraper.Tweet{
+		ID:               "1",
+		Text:             "Liftoff! #Launch #space",
+		Likes:            1500,
+		Retweets:         200,
+		Views:            90000,
+		IsReply:          true,
+		SensitiveContent: false,
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want bool
+	}{
+		{"none", Options{}, true},
+		{"min likes", Options{MinLikes: 1500}, true},
+		{"min likes above", Options{MinLikes: 1501}, false},
+		{"min retweets", Options{MinRetweets: 201}, false},
+		{"min views", Options{MinViews: 100000}, false},
+		{"include", Options{IncludeRegex: `(?i)liftoff`}, true},
+		{"include missing", Options{IncludeRegex: `landing`}, false},
+		{"exclude", Options{ExcludeRegex: `Liftoff`}, false},
+		{"hashtag from text", Options{Hashtags: []string{"#launch"}}, true},
+		{"other hashtag", Options{Hashtags: []string{"mars", "moon"}}, false},
+		{"exclude replies", Options{ExcludeReplies: true}, false},
+		{"only sensitive", Options{OnlySensitive: true}, false},
+	}
+	for _, test := range tests {
+		f, err := newTweetFilter(test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, reason := f.match(tweet); got != test.want {
+			t.Errorf("%s: match = %v (%s), want %v", test.name, got, reason, test.want)
+		}
+	}
+
+	// A retweet is filtered on the retweeted tweet.
+	retweet := &twitterscraper.Tweet{ID: "2", IsRetweet: true, Text: "RT @a: Liftoff!", RetweetedStatus: tweet}
+	f, _ := newTweetFilter(Options{MinLikes: 1000, Hashtags: []string{"space"}})
+	if ok, reason := f.match(retweet); !ok {
+		t.Errorf("retweet: %s", reason)
+	}
+
+	if _, err := newTweetFilter(Options{IncludeRegex: "("}); err == nil {
+		t.Error("invalid include regex accepted")
+	}
+}
+
+func TestDownloadUserFilter(t *testing.T) {
+	opts := allOptions()
+	opts.MinLikes = 100
+	opts.ExcludeRegex = "video"
+	d, fake, media := newTestDownloader(t, opts)
+	result, err := d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only 1003 has enough likes without "video" in its text.
+	if result.Tweets != 1 || len(result.Downloaded) != 2 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if media.total() != 2 {
+		t.Errorf("%d media requests", media.total())
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("requests = %v", fake.requests)
+	}
+}
+
+func TestDownloadUserFilterExpr(t *testing.T) {
+	opts := allOptions()
+	opts.Filter = `media_count >= 2 or text contains "gif"`
+	d, _, _ := newTestDownloader(t, opts)
+	result, err := d.DownloadUser(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tweets != 2 || len(result.Downloaded) != 3 {
+		t.Errorf("Tweets = %d, Downloaded = %v", result.Tweets, result.Downloaded)
+	}
+
+	opts.Filter = "likes >"
+	if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "invalid filter: expected a field or a value at column 8") {
+		t.Errorf("New: err = %v", err)
+	}
+}
+
+func TestDownloadUserMediaFilters(t *testing.T) {
+	download := func(opts Options) (string, string, string) {
+		t.Helper()
+		d, _, _ := newTestDownloader(t, opts)
+		if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+			t.Fatal(err)
+		}
+		output := filepath.Join(d.opts.Output, "fixture_user")
+		return strings.Join(files(t, output+"/img", "*"), ","), strings.Join(files(t, output+"/video", "*.mp4"), ","), strings.Join(files(t, output+"/gif", "*"), ",")
+	}
+
+	// photo_a is 1200x800, photo_b 600x900 and the video 720x1280.
+	opts := allOptions()
+	opts.Orientation = "portrait"
+	img, video, gif := download(opts)
+	if !strings.Contains(img, "photo_b") || strings.Contains(img, "photo_a") || video == "" || gif == "" {
+		t.Errorf("portrait: img = %s, video = %s, gif = %s", img, video, gif)
+	}
+
+	opts = allOptions()
+	opts.MinWidth = 700
+	opts.MinDuration = 20 * time.Second
+	img, video, gif = download(opts)
+	if !strings.Contains(img, "photo_a") || strings.Contains(img, "photo_b") || video != "" || gif == "" {
+		t.Errorf("min width and duration: img = %s, video = %s, gif = %s", img, video, gif)
+	}
+
+	// The video is the only file over 2000 bytes.
+	opts = allOptions()
+	opts.MaxFileSize = 2000
+	img, video, gif = download(opts)
+	if strings.Count(img, ".jpg") != 2 || video != "" || gif == "" {
+		t.Errorf("max file size: img = %s, video = %s, gif = %s", img, video, gif)
+	}
+}
@@ -0,0 +1,351 @@
+package downloader
+
+import (
+	"encoding/json"
+	"net/http"
+	URL "net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// The scraper has no method for some timelines (likes, lists...). They are
+// requested with RequestAPI and decoded here into twitterscraper tweets.
+
+// timelineFeatures are the features sent with the timeline requests, the
+// same as the scraper's UserTweets.
+var timelineFeatures = map[string]interface{}{
+	"rweb_lists_timeline_redesign_enabled":                              true,
+	"responsive_web_graphql_exclude_directive_enabled":                  true,
+	"verified_phone_label_enabled":                                      false,
+	"creator_subscriptions_tweet_preview_api_enabled":                   true,
+	"responsive_web_graphql_timeline_navigation_enabled":                true,
+	"responsive_web_graphql_skip_user_profile_image_extensions_enabled": false,
+	"tweetypie_unmention_optimization_enabled":                          true,
+	"vibe_api_enabled":                                                        true,
+	"responsive_web_edit_tweet_api_enabled":                                   true,
+	"graphql_is_translatable_rweb_tweet_is_translatable_enabled":              true,
+	"view_counts_everywhere_api_enabled":                                      true,
+	"longform_notetweets_consumption_enabled":                                 true,
+	"tweet_awards_web_tipping_enabled":                                        false,
+	"freedom_of_speech_not_reach_fetch_enabled":                               true,
+	"standardized_nudges_misinfo":                                             true,
+	"tweet_with_visibility_results_prefer_gql_limited_actions_policy_enabled": false,
+	"interactive_text_enabled":                                                true,
+	"responsive_web_text_conversations_enabled":                               false,
+	"longform_notetweets_rich_text_read_enabled":                              true,
+	"longform_notetweets_inline_media_enabled":                                false,
+	"responsive_web_enhance_cards_enabled":                                    false,
+}
+
+type gqlUser struct {
+	RestID string `json:"rest_id"`
+	Core   struct {
+		ScreenName string `json:"screen_name"`
+		Name       string `json:"name"`
+	} `json:"core"`
+	Legacy struct {
+		ScreenName string `json:"screen_name"`
+		Name       string `json:"name"`
+	} `json:"legacy"`
+}
+
+type gqlLegacyTweet struct {
+	IDStr                string `json:"id_str"`
+	UserIDStr            string `json:"user_id_str"`
+	ConversationIDStr    string `json:"conversation_id_str"`
+	InReplyToStatusIDStr string `json:"in_reply_to_status_id_str"`
+	QuotedStatusIDStr    string `json:"quoted_status_id_str"`
+	FullText             string `json:"full_text"`
+	CreatedAt            string `json:"created_at"`
+	FavoriteCount        int    `json:"favorite_count"`
+	RetweetCount         int    `json:"retweet_count"`
+	ReplyCount           int    `json:"reply_count"`
+	PossiblySensitive    bool   `json:"possibly_sensitive"`
+	Entities             struct {
+		Hashtags []struct {
+			Text string `json:"text"`
+		} `json:"hashtags"`
+		URLs []struct {
+			ExpandedURL string `json:"expanded_url"`
+		} `json:"urls"`
+	} `json:"entities"`
+	ExtendedEntities struct {
+		Media []mediaDetail `json:"media"`
+	} `json:"extended_entities"`
+	RetweetedStatusResult struct {
+		Result *gqlResult `json:"result"`
+	} `json:"retweeted_status_result"`
+}
+
+type gqlTweet struct {
+	RestID string `json:"rest_id"`
+	Core   struct {
+		UserResults struct {
+			Result gqlUser `json:"result"`
+		} `json:"user_results"`
+	} `json:"core"`
+	Views struct {
+		Count string `json:"count"`
+	} `json:"views"`
+	NoteTweet struct {
+		NoteTweetResults struct {
+			Result struct {
+				Text string `json:"text"`
+			} `json:"result"`
+		} `json:"note_tweet_results"`
+	} `json:"note_tweet"`
+	QuotedStatusResult struct {
+		Result *gqlResult `json:"result"`
+	} `json:"quoted_status_result"`
+	Legacy gqlLegacyTweet `json:"legacy"`
+}
+
+// gqlResult is a tweet_results.result. Tweets with visibility restrictions
+// are wrapped in a "tweet" field.
+type gqlResult struct {
+	Typename string `json:"__typename"`
+	gqlTweet
+	Tweet gqlTweet `json:"tweet"`
+}
+
+type gqlEntryContent struct {
+	CursorType  string `json:"cursorType"`
+	Value       string `json:"value"`
+	ItemContent struct {
+		TweetResults struct {
+			Result *gqlResult `json:"result"`
+		} `json:"tweet_results"`
+		UserResults struct {
+			Result *gqlUser `json:"result"`
+		} `json:"user_results"`
+		CursorType string `json:"cursorType"`
+		Value      string `json:"value"`
+	} `json:"itemContent"`
+	Items []struct {
+		Item gqlEntryContent `json:"item"`
+	} `json:"items"`
+}
+
+type gqlInstruction struct {
+	Type    string `json:"type"`
+	Entries []struct {
+		EntryID string          `json:"entryId"`
+		Content gqlEntryContent `json:"content"`
+	} `json:"entries"`
+	Entry struct {
+		EntryID string          `json:"entryId"`
+		Content gqlEntryContent `json:"content"`
+	} `json:"entry"`
+}
+
+// gqlTimeline is a decoded timeline page.
+type gqlTimeline struct {
+	tweets []*twitterscraper.Tweet
+	users  []*gqlUser
+	media  map[string][]mediaDetail
+	cursor string
+}
+
+// findInstructions returns the first "instructions" array of a payload,
+// wherever the timeline is (data.user.result.timeline_v2.timeline,
+// data.list.tweets_timeline.timeline...).
+func findInstructions(v interface{}) []interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if instructions, ok := t["instructions"].([]interface{}); ok {
+			return instructions
+		}
+		keys := make([]string, 0, len(t))
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if instructions := findInstructions(t[key]); instructions != nil {
+				return instructions
+			}
+		}
+	case []interface{}:
+		for _, item := range t {
+			if instructions := findInstructions(item); instructions != nil {
+				return instructions
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimeline decodes a GraphQL timeline payload.
+func parseTimeline(payload json.RawMessage) (*gqlTimeline, error) {
+	var raw interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+	js, err := json.Marshal(findInstructions(raw))
+	if err != nil {
+		return nil, err
+	}
+	var instructions []gqlInstruction
+	if err := json.Unmarshal(js, &instructions); err != nil {
+		return nil, err
+	}
+
+	timeline := &gqlTimeline{media: map[string][]mediaDetail{}}
+	var add func(content gqlEntryContent)
+	add = func(content gqlEntryContent) {
+		switch {
+		case content.CursorType == "Bottom":
+			timeline.cursor = content.Value
+		case content.ItemContent.CursorType == "Bottom":
+			timeline.cursor = content.ItemContent.Value
+		case content.ItemContent.TweetResults.Result != nil:
+			result := content.ItemContent.TweetResults.Result
+			if tweet := result.tweet(); tweet != nil {
+				timeline.tweets = append(timeline.tweets, tweet)
+				timeline.media[tweet.ID] = result.legacy().ExtendedEntities.Media
+			}
+		case content.ItemContent.UserResults.Result != nil:
+			timeline.users = append(timeline.users, content.ItemContent.UserResults.Result)
+		}
+		for _, item := range content.Items {
+			add(item.Item)
+		}
+	}
+	for _, instruction := range instructions {
+		for _, entry := range instruction.Entries {
+			add(entry.Content)
+		}
+		add(instruction.Entry.Content)
+	}
+	return timeline, nil
+}
+
+func (r *gqlResult) unwrap() *gqlTweet {
+	if r.Typename == "TweetWithVisibilityResults" {
+		return &r.Tweet
+	}
+	return &r.gqlTweet
+}
+
+func (r *gqlResult) legacy() *gqlLegacyTweet {
+	return &r.unwrap().Legacy
+}
+
+func (u *gqlUser) screenName() (string, string) {
+	if u.Core.ScreenName != "" {
+		return u.Core.ScreenName, u.Core.Name
+	}
+	return u.Legacy.ScreenName, u.Legacy.Name
+}
+
+// tweet converts the result like the scraper does for its own timelines.
+func (r *gqlResult) tweet() *twitterscraper.Tweet {
+	t := r.unwrap()
+	legacy := &t.Legacy
+	if legacy.IDStr == "" {
+		return nil
+	}
+	username, name := t.Core.UserResults.Result.screenName()
+	text := legacy.FullText
+	if t.NoteTweet.NoteTweetResults.Result.Text != "" {
+		text = t.NoteTweet.NoteTweetResults.Result.Text
+	}
+
+	tweet := &twitterscraper.Tweet{
+		ConversationID:    legacy.ConversationIDStr,
+		ID:                legacy.IDStr,
+		InReplyToStatusID: legacy.InReplyToStatusIDStr,
+		IsReply:           legacy.InReplyToStatusIDStr != "",
+		IsQuoted:          legacy.QuotedStatusIDStr != "",
+		QuotedStatusID:    legacy.QuotedStatusIDStr,
+		Likes:             legacy.FavoriteCount,
+		Name:              name,
+		PermanentURL:      "https://x.com/" + username + "/status/" + legacy.IDStr,
+		Replies:           legacy.ReplyCount,
+		Retweets:          legacy.RetweetCount,
+		Text:              text,
+		UserID:            legacy.UserIDStr,
+		Username:          username,
+		SensitiveContent:  legacy.PossiblySensitive,
+	}
+	if tm, err := time.Parse(time.RubyDate, legacy.CreatedAt); err == nil {
+		tweet.TimeParsed = tm
+		tweet.Timestamp = tm.Unix()
+	}
+	tweet.Views, _ = strconv.Atoi(t.Views.Count)
+	for _, hashtag := range legacy.Entities.Hashtags {
+		tweet.Hashtags = append(tweet.Hashtags, hashtag.Text)
+	}
+	for _, url := range legacy.Entities.URLs {
+		tweet.URLs = append(tweet.URLs, url.ExpandedURL)
+	}
+	if rt := legacy.RetweetedStatusResult.Result; rt != nil {
+		tweet.IsRetweet = true
+		if tweet.RetweetedStatus = rt.tweet(); tweet.RetweetedStatus != nil {
+			tweet.RetweetedStatusID = tweet.RetweetedStatus.ID
+		}
+	}
+	if quoted := t.QuotedStatusResult.Result; quoted != nil {
+		tweet.QuotedStatus = quoted.tweet()
+	}
+
+	for _, m := range legacy.ExtendedEntities.Media {
+		switch m.Type {
+		case "photo":
+			tweet.Photos = append(tweet.Photos, twitterscraper.Photo{ID: m.IDStr, URL: m.MediaURLHttps})
+		case "video":
+			video := twitterscraper.Video{ID: m.IDStr, Preview: m.MediaURLHttps}
+			bitrate := -1
+			for _, v := range m.VideoInfo.Variants {
+				if v.ContentType == "application/x-mpegURL" {
+					video.HLSURL = v.URL
+				} else if v.Bitrate > bitrate {
+					video.URL = v.URL
+					bitrate = v.Bitrate
+				}
+			}
+			tweet.Videos = append(tweet.Videos, video)
+		case "animated_gif":
+			gif := twitterscraper.GIF{ID: m.IDStr, Preview: m.MediaURLHttps}
+			if len(m.VideoInfo.Variants) > 0 {
+				gif.URL = m.VideoInfo.Variants[0].URL
+			}
+			tweet.GIFs = append(tweet.GIFs, gif)
+		}
+	}
+	return tweet
+}
+
+// requestTimeline requests a page of a GraphQL timeline. The media details
+// of its tweets are cached, so that listing video variants doesn't need
+// another request.
+func (d *Downloader) requestTimeline(endpoint string, variables map[string]interface{}) (*gqlTimeline, error) {
+	vars, _ := json.Marshal(variables)
+	features, _ := json.Marshal(timelineFeatures)
+	query := URL.Values{}
+	query.Set("variables", string(vars))
+	query.Set("features", string(features))
+	req, err := http.NewRequestWithContext(d.ctx, "GET", endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload json.RawMessage
+	if err := d.scraper.RequestAPI(req, &payload); err != nil {
+		return nil, err
+	}
+	timeline, err := parseTimeline(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mediaDetailsLock.Lock()
+	for id, media := range timeline.media {
+		d.mediaDetails[id] = media
+	}
+	d.mediaDetailsLock.Unlock()
+	return timeline, nil
+}
@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+const likesURL = "https://x.com/i/api/graphql/aeJWz--kknVBOl7wQ7gh7Q/Likes"
+
+// fetchLikes returns a page of the tweets liked by the user with userID.
+// Only the likes the logged in account can see are returned.
+func (d *Downloader) fetchLikes(userID string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	if maxTweetsNbr > 200 {
+		maxTweetsNbr = 200
+	}
+	variables := map[string]interface{}{
+		"userId":                 userID,
+		"count":                  maxTweetsNbr,
+		"includePromotedContent": false,
+		"withClientEventToken":   false,
+		"withBirdwatchNotes":     false,
+		"withVoice":              true,
+		"withV2Timeline":         true,
+	}
+	if cursor != "" {
+		variables["cursor"] = cursor
+	}
+	timeline, err := d.requestTimeline(likesURL, variables)
+	if err != nil {
+		return nil, "", err
+	}
+	// The last pages only hold cursors.
+	if len(timeline.tweets) == 0 {
+		return nil, "", nil
+	}
+	return timeline.tweets, timeline.cursor, nil
+}
@@ -0,0 +1,104 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadLikes(t *testing.T) {
+	opts := allOptions()
+	opts.SinceID = "3000"
+	d, fake, media := newTestDownloader(t, opts)
+	result, err := d.DownloadLikes(context.Background(), "fixture_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user", "likes")
+
+	// SinceID is ignored, likes aren't ordered by tweet id.
+	if result.Tweets != 3 || len(result.Downloaded) != 3 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if got := files(t, output+"/img", "*_liked_A liked photo #tag.jpg"); len(got) != 1 {
+		t.Errorf("img = %v", files(t, output+"/img", "*"))
+	}
+	if got := files(t, output+"/video", "*_video_1280_A liked video.mp4"); len(got) != 1 {
+		t.Errorf("video = %v", files(t, output+"/video", "*"))
+	}
+	if got := files(t, output+"/gif", "*_liked_A liked gif.mp4"); len(got) != 1 {
+		t.Errorf("gif = %v", files(t, output+"/gif", "*"))
+	}
+	if media.count("/ext_tw_video/4002/pu/vid/1280x720/video_1280.mp4") != 1 {
+		t.Error("best variant not downloaded")
+	}
+
+	// The variants come with the timeline, the details aren't requested.
+	want := []string{
+		"GetUserIDByScreenName fixture_user",
+		"RequestAPI Likes ",
+		"RequestAPI Likes page2",
+		"RequestAPI Likes page3",
+	}
+	if strings.Join(fake.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q", fake.requests)
+	}
+
+	state, err := loadState(output + "/twmd_state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.NewestTweetID != "3002" || state.LastTweetID != "2500" {
+		t.Errorf("state = %+v", state)
+	}
+}
+
+func TestDownloadLikesUnknownUser(t *testing.T) {
+	d, _, _ := newTestDownloader(t, allOptions())
+	if _, err := d.DownloadLikes(context.Background(), "nobody"); err == nil {
+		t.Error("DownloadLikes of an unknown user should fail")
+	}
+}
+
+func TestParseTimeline(t *testing.T) {
+	js, err := os.ReadFile(filepath.Join("testdata", "likes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeline, err := parseTimeline(json.RawMessage(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline.tweets) != 2 || timeline.cursor != "page2" {
+		t.Fatalf("%d tweets, cursor %q", len(timeline.tweets), timeline.cursor)
+	}
+
+	photo := timeline.tweets[0]
+	if photo.ID != "3001" || photo.Username != "liked_user" || photo.Name != "Liked User" || photo.UserID != "77" {
+		t.Errorf("tweet = %+v", photo)
+	}
+	if photo.Likes != 42 || photo.Retweets != 3 || photo.Replies != 1 || photo.Views != 1500 {
+		t.Errorf("counts = %d %d %d %d", photo.Likes, photo.Retweets, photo.Replies, photo.Views)
+	}
+	if photo.Timestamp != 1710000000 || photo.PermanentURL != "https://x.com/liked_user/status/3001" {
+		t.Errorf("timestamp = %d, url = %s", photo.Timestamp, photo.PermanentURL)
+	}
+	if len(photo.Hashtags) != 1 || photo.Hashtags[0] != "tag" || len(photo.Photos) != 1 || photo.Photos[0].ID != "4001" {
+		t.Errorf("hashtags = %v, photos = %v", photo.Hashtags, photo.Photos)
+	}
+
+	// Tweets with visibility results are unwrapped.
+	video := timeline.tweets[1]
+	if video.ID != "3002" || video.Username != "video_user" || len(video.Videos) != 1 {
+		t.Fatalf("tweet = %+v", video)
+	}
+	if !strings.HasSuffix(video.Videos[0].URL, "video_1280.mp4?tag=12") || !strings.HasSuffix(video.Videos[0].HLSURL, ".m3u8?tag=12") {
+		t.Errorf("video = %+v", video.Videos[0])
+	}
+	if len(timeline.media["3002"]) != 1 || len(timeline.media["3002"][0].VideoInfo.Variants) != 3 {
+		t.Errorf("media = %+v", timeline.media["3002"])
+	}
+}
@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+const (
+	listTweetsURL  = "https://x.com/i/api/graphql/HjsWc-nwwHKYwHenbHm-tw/ListLatestTweetsTimeline"
+	listMembersURL = "https://x.com/i/api/graphql/BQp2IEYkgxuSxqbTAr1e1g/ListMembers"
+)
+
+// fetchListTweets returns a page of the latest tweets of the members of a
+// list.
+func (d *Downloader) fetchListTweets(listID string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	if maxTweetsNbr > 200 {
+		maxTweetsNbr = 200
+	}
+	variables := map[string]interface{}{
+		"listId": listID,
+		"count":  maxTweetsNbr,
+	}
+	if cursor != "" {
+		variables["cursor"] = cursor
+	}
+	timeline, err := d.requestTimeline(listTweetsURL, variables)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(timeline.tweets) == 0 {
+		return nil, "", nil
+	}
+	return timeline.tweets, timeline.cursor, nil
+}
+
+// fetchListMembers returns a page of the screen names of the members of a
+// list.
+func (d *Downloader) fetchListMembers(listID string, cursor string) ([]string, string, error) {
+	variables := map[string]interface{}{
+		"listId":                   listID,
+		"count":                    100,
+		"withSafetyModeUserFields": true,
+	}
+	if cursor != "" {
+		variables["cursor"] = cursor
+	}
+	timeline, err := d.requestTimeline(listMembersURL, variables)
+	if err != nil {
+		return nil, "", err
+	}
+	var names []string
+	for _, user := range timeline.users {
+		if name, _ := user.screenName(); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, "", nil
+	}
+	return names, timeline.cursor, nil
+}
+
+// DownloadList crawls the latest tweets of a list and downloads their media
+// in Output/list/LIST_ID.
+func (d *Downloader) DownloadList(ctx context.Context, listID string) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	return result, d.downloadTimeline(ctx, d.listTimeline(listID))
+}
+
+// listTimeline is the timeline crawled by DownloadList.
+func (d *Downloader) listTimeline(listID string) timeline {
+	return timeline{
+		name:    "list " + listID,
+		query:   listID,
+		output:  d.opts.Output + "/list/" + listID,
+		fetch:   d.fetchListTweets,
+		ordered: true,
+	}
+}
+
+// DownloadListMembers downloads the timeline of every member of a list, as
+// DownloadUser does, in one run. A member that fails is logged and skipped.
+func (d *Downloader) DownloadListMembers(ctx context.Context, listID string) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	var members []string
+	cursor := ""
+	for {
+		var names []string
+		var next string
+		err := d.retry429(func() (err error) {
+			if err := d.waitForRateLimit(); err != nil {
+				return err
+			}
+			names, next, err = d.fetchListMembers(listID, cursor)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			return result, fmt.Errorf("error fetching the members of list %s: %w", listID, err)
+		}
+		members = append(members, names...)
+		if len(names) == 0 || next == "" || next == cursor {
+			break
+		}
+		cursor = next
+	}
+	d.log.Infof("List %s has %d members", listID, len(members))
+
+	for i, name := range members {
+		d.log.Infof("Downloading member %d/%d: %s", i+1, len(members), name)
+		err := d.downloadTimeline(ctx, d.userTimeline(name))
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if err != nil {
+			d.log.Errorf("Failed to download %s: %s", name, err.Error())
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+package downloader
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadList(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Images = true
+	d, fake, _ := newTestDownloader(t, opts)
+	result, err := d.DownloadList(context.Background(), "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tweets != 1 || len(result.Downloaded) != 1 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	output := filepath.Join(d.opts.Output, "list", "1234")
+	if got := files(t, output+"/img", "*_list_A list photo.jpg"); len(got) != 1 {
+		t.Errorf("img = %v", files(t, output+"/img", "*"))
+	}
+	if got := strings.Join(fake.requests, ","); got != "RequestAPI ListLatestTweetsTimeline ,RequestAPI ListLatestTweetsTimeline list2" {
+		t.Errorf("requests = %s", got)
+	}
+}
+
+func TestDownloadListMembers(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Images = true
+	d, fake, _ := newTestDownloader(t, opts)
+	result, err := d.DownloadListMembers(context.Background(), "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake returns the same timeline for every user.
+	if result.Tweets != 8 || len(result.Downloaded) != 4 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	for _, name := range []string{"fixture_user", "member_user"} {
+		if got := files(t, filepath.Join(d.opts.Output, name, "img"), "*"); len(got) != 2 {
+			t.Errorf("%s/img = %v", name, got)
+		}
+	}
+	want := []string{
+		"RequestAPI ListMembers ",
+		"RequestAPI ListMembers members2",
+		"RequestAPI ListMembers members3",
+		"FetchTweets fixture_user ",
+		"FetchTweets fixture_user 2",
+		"FetchTweets member_user ",
+		"FetchTweets member_user 2",
+	}
+	if got := strings.Join(fake.requests, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("requests = %q", fake.requests)
+	}
+}
+
+func TestDownloadListMembersCancel(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, allOptions())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.DownloadListMembers(ctx, "1234"); err != context.Canceled {
+		t.Errorf("err = %v", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("requests = %v", fake.requests)
+	}
+}
@@ -0,0 +1,430 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// download saves the media at the path of its plan and returns it, or an
+// empty string if nothing was downloaded.
+func (d *Downloader) download(wg *sync.WaitGroup, tweet interface{}, plan mediaPlan) string {
+	defer wg.Done()
+	url := plan.url
+	name := filepath.Base(plan.path)
+
+	// Log download start
+	d.log.Infof("Starting download: %s", name)
+	d.log.Infof("URL: %s", url)
+	d.log.Infof("Output directory: %s", plan.output)
+
+	if d.opts.URLOnly {
+		d.log.Info(url)
+		time.Sleep(2 * time.Millisecond)
+		return ""
+	}
+	if d.ctx.Err() != nil {
+		return ""
+	}
+	if d.skipped(tweet, plan) {
+		return ""
+	}
+
+	path := plan.path
+
+	plan.makeDir()
+	d.log.Infof("Download started: %s", name)
+	written, sum, err := d.fetchWithRetry(tweetID(tweet), url, path)
+	if err != nil {
+		d.log.Errorf("Download failed: %s", err.Error())
+		return ""
+	}
+	d.log.Infof("Download completed: %s", name)
+	d.recordDownload(tweetID(tweet), url, path, written)
+	d.archiveMedia(tweet, plan, path, written, sum)
+	return path
+}
+
+// archiveMedia records the file saved for a media in the archive of its
+// output.
+func (d *Downloader) archiveMedia(tweet interface{}, plan mediaPlan, path string, size int64, sum string) {
+	a, err := d.archive(plan.output)
+	if err != nil {
+		return
+	}
+	err = a.add(archiveEntry{
+		TweetID:  tweetID(tweet),
+		MediaKey: mediaKey(plan.url),
+		Path:     path,
+		Size:     size,
+		Hash:     sum,
+	})
+	if err != nil {
+		d.log.Errorf("Failed to update archive: %s", err.Error())
+	}
+}
+
+// skipped reports whether Update skips a media, already in the archive or on
+// disk.
+func (d *Downloader) skipped(tweet interface{}, plan mediaPlan) bool {
+	if d.archived(tweet, plan.url, plan.output) {
+		return true
+	}
+	if d.opts.Update && plan.exists() {
+		d.log.Infof("File already exists: %s", filepath.Base(plan.path))
+		return true
+	}
+	return false
+}
+
+// queueVideo queues the download of a video and its thumbnail, and writes
+// its NFO, ASS and JSON sidecars, all from the same plan. Nothing is written
+// for a skipped video.
+func (d *Downloader) queueVideo(wg *sync.WaitGroup, tweet interface{}, video twitterscraper.Video, variant *videoVariant, plan mediaPlan) {
+	if d.skipped(tweet, plan) {
+		return
+	}
+	wg.Add(1)
+	d.queue(func() { d.download(wg, tweet, plan) })
+	// Download video thumbnail
+	wg.Add(1)
+	d.queue(func() { d.downloadThumbnail(wg, tweet, video, plan) })
+	// Generate NFO file
+	d.generateNFOFile(tweet, plan)
+	// Generate ASS subtitle file
+	d.generateASSFile(tweet, plan)
+	// Save tweet JSON
+	d.saveTweetJSON(tweet, variant, plan)
+}
+
+func (d *Downloader) videoUser(wait *sync.WaitGroup, tweet *twitterscraper.TweetResult, output string, rt bool) {
+	defer wait.Done()
+	wg := sync.WaitGroup{}
+	if len(tweet.Videos) > 0 {
+		d.log.Infof("Processing %d videos for tweet: %s", len(tweet.Videos), tweet.ID)
+		for _, i := range tweet.Videos {
+			if tweet.IsRetweet && !(rt || d.opts.RetweetsOnly) {
+				continue
+			} else if !tweet.IsRetweet && d.opts.RetweetsOnly {
+				continue
+			}
+			if d.archived(tweet, strings.Split(i.URL, "?")[0], output) {
+				continue
+			}
+			variant := d.chooseVariant(tweet.ID, i)
+			url := variant.URL
+			d.log.Infof("Processing video: %s", url)
+			if url != strings.Split(i.URL, "?")[0] && d.archived(tweet, url, output) {
+				continue
+			}
+			if !d.keepMedia(tweet.ID, i.ID, url, "video") {
+				continue
+			}
+			d.queueVideo(&wg, tweet, i, variant, d.planMedia(tweet, "video", i.ID, url, output, "user", tweet.IsRetweet))
+		}
+		wg.Wait()
+	}
+}
+
+func (d *Downloader) photoUser(wait *sync.WaitGroup, tweet *twitterscraper.TweetResult, output string, rt bool) {
+	defer wait.Done()
+	wg := sync.WaitGroup{}
+	if len(tweet.Photos) > 0 || tweet.IsRetweet {
+		// The videos and gifs of retweets are downloaded by videoUser and
+		// gifUser.
+		if tweet.IsRetweet && (rt || d.opts.RetweetsOnly) {
+			if retweet, err := d.fetchTweet(tweet.ID); err != nil {
+				d.log.Error(err.Error())
+			} else {
+				d.photoSingle(retweet, output, true)
+			}
+		}
+		for _, i := range tweet.Photos {
+			if d.opts.RetweetsOnly || tweet.IsRetweet {
+				continue
+			}
+			var url string
+			if !strings.Contains(i.URL, "video_thumb/") {
+				if d.opts.Size == "orig" || d.opts.Size == "small" {
+					url = i.URL + "?name=" + d.opts.Size
+				} else {
+					url = i.URL
+				}
+				if !d.keepMedia(tweet.ID, i.ID, url, "photo") {
+					continue
+				}
+				plan := d.planMedia(tweet, "photo", i.ID, url, output, "user", false)
+				wg.Add(1)
+				d.queue(func() { d.download(&wg, tweet, plan) })
+			}
+		}
+		wg.Wait()
+	}
+}
+
+func (d *Downloader) gifUser(wait *sync.WaitGroup, tweet *twitterscraper.TweetResult, output string, rt bool) {
+	defer wait.Done()
+	wg := sync.WaitGroup{}
+	if len(tweet.GIFs) > 0 {
+		d.log.Infof("Processing %d gifs for tweet: %s", len(tweet.GIFs), tweet.ID)
+		for _, i := range tweet.GIFs {
+			if tweet.IsRetweet && !(rt || d.opts.RetweetsOnly) {
+				continue
+			} else if !tweet.IsRetweet && d.opts.RetweetsOnly {
+				continue
+			}
+			if !d.keepMedia(tweet.ID, i.ID, i.URL, "gif") {
+				continue
+			}
+			plan := d.planMedia(tweet, "gif", i.ID, i.URL, output, "user", tweet.IsRetweet)
+			wg.Add(1)
+			d.queue(func() { d.downloadGIF(&wg, tweet, plan) })
+		}
+		wg.Wait()
+	}
+}
+
+// downloadGIF downloads the MP4 source of an animated gif and converts it
+// to GIFFormat. The converted file replaces the source in the archive and
+// the result.
+func (d *Downloader) downloadGIF(wg *sync.WaitGroup, tweet interface{}, plan mediaPlan) {
+	defer wg.Done()
+	dwg := sync.WaitGroup{}
+	dwg.Add(1)
+	path := d.download(&dwg, tweet, plan)
+	if path == "" || plan.converted == "" || !d.convertGIF(path, plan.converted) {
+		return
+	}
+	size, sum, err := hashFile(plan.converted)
+	if err != nil {
+		d.log.Errorf("Failed to read converted gif: %s", err.Error())
+		return
+	}
+	d.resultLock.Lock()
+	if d.result != nil {
+		for i, m := range d.result.Downloaded {
+			if m.Path == path {
+				d.result.Downloaded[i].Path, d.result.Downloaded[i].Size = plan.converted, size
+			}
+		}
+	}
+	d.resultLock.Unlock()
+	d.archiveMedia(tweet, plan, plan.converted, size, sum)
+}
+
+// convertGIF converts a gif MP4 source with ffmpeg to out and removes the
+// MP4. It reports whether the conversion succeeded.
+func (d *Downloader) convertGIF(path string, out string) bool {
+	var args []string
+	switch d.opts.GIFFormat {
+	case "gif":
+		args = []string{"-y", "-loglevel", "error", "-i", path,
+			"-vf", "split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse", "-loop", "0", out}
+	case "webp":
+		args = []string{"-y", "-loglevel", "error", "-i", path,
+			"-c:v", "libwebp", "-lossless", "0", "-q:v", "75", "-loop", "0", "-an", out}
+	}
+	d.log.Infof("Converting gif to %s: %s", d.opts.GIFFormat, out)
+	if msg, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		d.log.Errorf("Failed to convert gif %s: %s %s", path, err.Error(), strings.TrimSpace(string(msg)))
+		os.Remove(out)
+		return false
+	}
+	os.Remove(path)
+	return true
+}
+
+// singleLayout returns where the media of a single tweet go: in the img,
+// video and gif directories with the RE- prefix for the retweets of a user
+// timeline, directly in output otherwise.
+func singleLayout(rt bool) string {
+	if rt {
+		return "user"
+	}
+	return "tweet"
+}
+
+func (d *Downloader) gifSingle(tweet *twitterscraper.Tweet, output string, rt bool) {
+	if tweet == nil {
+		return
+	}
+	if len(tweet.GIFs) > 0 {
+		wg := sync.WaitGroup{}
+		for _, i := range tweet.GIFs {
+			if !d.keepMedia(tweet.ID, i.ID, i.URL, "gif") {
+				continue
+			}
+			plan := d.planMedia(tweet, "gif", i.ID, i.URL, output, singleLayout(rt), rt)
+			wg.Add(1)
+			d.queue(func() { d.downloadGIF(&wg, tweet, plan) })
+		}
+		wg.Wait()
+	}
+}
+
+func (d *Downloader) videoSingle(tweet *twitterscraper.Tweet, output string, rt bool) {
+	if tweet == nil {
+		return
+	}
+	if len(tweet.Videos) > 0 {
+		wg := sync.WaitGroup{}
+		for _, i := range tweet.Videos {
+			if d.archived(tweet, strings.Split(i.URL, "?")[0], output) {
+				continue
+			}
+			variant := d.chooseVariant(tweet.ID, i)
+			url := variant.URL
+			if url != strings.Split(i.URL, "?")[0] && d.archived(tweet, url, output) {
+				continue
+			}
+			if !d.keepMedia(tweet.ID, i.ID, url, "video") {
+				continue
+			}
+			d.queueVideo(&wg, tweet, i, variant, d.planMedia(tweet, "video", i.ID, url, output, singleLayout(rt), rt))
+		}
+		wg.Wait()
+	}
+}
+
+func (d *Downloader) photoSingle(tweet *twitterscraper.Tweet, output string, rt bool) {
+	if tweet == nil {
+		return
+	}
+	if len(tweet.Photos) > 0 {
+		wg := sync.WaitGroup{}
+		for _, i := range tweet.Photos {
+			var url string
+			if !strings.Contains(i.URL, "video_thumb/") {
+				if d.opts.Size == "orig" || d.opts.Size == "small" {
+					url = i.URL + "?name=" + d.opts.Size
+				} else {
+					url = i.URL
+				}
+				if !d.keepMedia(tweet.ID, i.ID, url, "photo") {
+					continue
+				}
+				plan := d.planMedia(tweet, "photo", i.ID, url, output, singleLayout(rt), rt)
+				wg.Add(1)
+				d.queue(func() { d.download(&wg, tweet, plan) })
+			}
+		}
+		wg.Wait()
+	}
+}
+
+// singleTweet downloads the media of a tweet, and follows its quote.
+func (d *Downloader) singleTweet(output string, id string) error {
+	tweet, err := d.fetchTweet(id)
+	if err != nil {
+		return err
+	}
+	d.videoSingle(tweet, output, false)
+	d.photoSingle(tweet, output, false)
+	d.gifSingle(tweet, output, false)
+	d.followQuote(tweet, output, 1)
+	return nil
+}
+
+// tweetMedia downloads the media of a tweet selected by selectMedia.
+func (d *Downloader) tweetMedia(output string, id string, mediaType string, index int) error {
+	tweet, err := d.fetchTweet(id)
+	if err != nil {
+		return err
+	}
+	selected, err := selectMedia(tweet, mediaType, index)
+	if err != nil {
+		return err
+	}
+	d.videoSingle(selected, output, false)
+	d.photoSingle(selected, output, false)
+	d.gifSingle(selected, output, false)
+	return nil
+}
+
+// selectMedia returns a copy of tweet keeping only the index-th photo, or the
+// index-th video or gif.
+func selectMedia(tweet *twitterscraper.Tweet, mediaType string, index int) (*twitterscraper.Tweet, error) {
+	selected := *tweet
+	selected.Photos, selected.Videos, selected.GIFs = nil, nil, nil
+	switch mediaType {
+	case "photo":
+		var photos []twitterscraper.Photo
+		for _, p := range tweet.Photos {
+			if !strings.Contains(p.URL, "video_thumb/") {
+				photos = append(photos, p)
+			}
+		}
+		if index < 1 || index > len(photos) {
+			return nil, fmt.Errorf("tweet %s has no photo %d, it has %d", tweet.ID, index, len(photos))
+		}
+		selected.Photos = photos[index-1 : index]
+	case "video":
+		count := len(tweet.Videos) + len(tweet.GIFs)
+		if index < 1 || index > count {
+			return nil, fmt.Errorf("tweet %s has no video %d, it has %d", tweet.ID, index, count)
+		}
+		if index <= len(tweet.Videos) {
+			selected.Videos = tweet.Videos[index-1 : index]
+		} else {
+			index -= len(tweet.Videos)
+			selected.GIFs = tweet.GIFs[index-1 : index]
+		}
+	default:
+		return nil, fmt.Errorf("invalid media type %q, expected photo or video", mediaType)
+	}
+	return &selected, nil
+}
+
+// fetchTweet gets a tweet, retrying failed requests. 429 errors are retried
+// by retry429.
+func (d *Downloader) fetchTweet(id string) (*twitterscraper.Tweet, error) {
+	var lastErr error
+	for retry := 0; retry < d.opts.MaxRetries; retry++ {
+		var tweet *twitterscraper.Tweet
+		err := d.retry429(func() (err error) {
+			if err := d.waitForRateLimit(); err != nil {
+				return err
+			}
+			tweet, err = d.scraper.GetTweet(id)
+			return err
+		})
+		if err != nil {
+			lastErr = err
+			if d.ctx.Err() != nil {
+				return nil, d.ctx.Err()
+			}
+			if is429(err) {
+				break
+			}
+			d.log.Errorf("Error fetching tweet: %s", err.Error())
+			if retry < d.opts.MaxRetries-1 {
+				waitTime := d.getRetryWaitTime(retry)
+				d.log.Infof("Retrying in %v (attempt %d/%d)", waitTime, retry+1, d.opts.MaxRetries)
+				if err := d.sleep(d.ctx, waitTime); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			break
+		}
+		if tweet == nil {
+			return nil, errors.New("error retrieve tweet")
+		}
+		d.checkAndPauseForBatch()
+		if d.ctx.Err() != nil {
+			return nil, d.ctx.Err()
+		}
+		return tweet, nil
+	}
+	if d.ctx.Err() != nil {
+		return nil, d.ctx.Err()
+	}
+	return nil, fmt.Errorf("failed to fetch tweet %s after %d retries: %w", id, d.opts.MaxRetries, lastErr)
+}
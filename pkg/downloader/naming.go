@@ -0,0 +1,350 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// defaultFileFormat names the media when Options.FileFormat is empty.
+const defaultFileFormat = "{DATE}_{FILENAME}_{TITLE:20}"
+
+// mediaSuffix is appended to a file format without FILENAME, MEDIA_ID, INDEX
+// or COUNTER, which would give the same name to every media of a tweet.
+const mediaSuffix = "_{FILENAME}"
+
+// maxNameBytes keeps each path segment under the 255 bytes of most file
+// systems, with room for the extension and the .part suffix.
+const maxNameBytes = 200
+
+// emptyText is returned by sanitizeText for a text left empty.
+const emptyText = "无内容"
+
+var invalidNameRegex = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]`)
+
+// nameFields are the fields of a file format. The ones in perMediaFields tell
+// the media of a tweet apart.
+var (
+	nameFields = map[string]bool{
+		"DATE": true, "TIME": true, "TIMESTAMP": true,
+		"ID": true, "USERNAME": true, "NAME": true, "TITLE": true,
+		"LIKES": true, "RETWEETS": true, "REPLIES": true, "VIEWS": true,
+		"FILENAME": true, "MEDIA_ID": true, "INDEX": true, "TYPE": true, "EXT": true,
+		"WIDTH": true, "HEIGHT": true, "COUNTER": true,
+	}
+	perMediaFields = map[string]bool{"FILENAME": true, "MEDIA_ID": true, "INDEX": true, "COUNTER": true}
+)
+
+// nameTemplate is a compiled file format such as
+// "{USERNAME}/{DATE}_{ID}_{INDEX:02}". Fields are written {FIELD} or
+// {FIELD:MODIFIER:...}, with the modifiers:
+//
+//	N      keep the first N characters
+//	0N     pad numbers with zeros to N digits
+//	upper  upper case
+//	lower  lower case
+//
+// A / in the format creates subdirectories.
+type nameTemplate struct {
+	parts    []namePart
+	perMedia bool
+	uses     map[string]bool
+}
+
+type namePart struct {
+	literal  string
+	field    string
+	truncate int
+	pad      int
+	upper    bool
+	lower    bool
+}
+
+// compileNameTemplate parses a file format. Field names are case insensitive.
+func compileNameTemplate(format string) (*nameTemplate, error) {
+	if strings.HasPrefix(format, "/") || strings.HasPrefix(format, `\`) {
+		return nil, errors.New("file format must be relative to the output directory")
+	}
+	for _, segment := range strings.Split(format, "/") {
+		if segment == ".." || segment == "." {
+			return nil, fmt.Errorf("file format can't contain %q directories", segment)
+		}
+	}
+	t := &nameTemplate{uses: map[string]bool{}}
+	for rest := format; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, namePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, namePart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated field %q in file format", rest[open:])
+		}
+		part, err := parseNamePart(rest[open+1 : open+end])
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, part)
+		t.uses[part.field] = true
+		t.perMedia = t.perMedia || perMediaFields[part.field]
+		rest = rest[open+end+1:]
+	}
+	if len(t.uses) == 0 {
+		return nil, errors.New("file format must contain a field such as {DATE}, {ID} or {INDEX}")
+	}
+	return t, nil
+}
+
+func parseNamePart(field string) (namePart, error) {
+	modifiers := strings.Split(field, ":")
+	part := namePart{field: strings.ToUpper(strings.TrimSpace(modifiers[0]))}
+	if !nameFields[part.field] {
+		return part, fmt.Errorf("unknown field {%s} in file format", modifiers[0])
+	}
+	for _, m := range modifiers[1:] {
+		n, err := strconv.Atoi(m)
+		switch {
+		case err == nil && strings.HasPrefix(m, "0") && n > 0:
+			part.pad = n
+		case err == nil && n > 0:
+			part.truncate = n
+		case strings.EqualFold(m, "upper"):
+			part.upper = true
+		case strings.EqualFold(m, "lower"):
+			part.lower = true
+		default:
+			return part, fmt.Errorf("unknown modifier %q in {%s}", m, field)
+		}
+	}
+	return part, nil
+}
+
+// nameMedia is the media named by a template.
+type nameMedia struct {
+	kind string // photo, video or gif
+	id   string
+	url  string
+}
+
+// mediaName returns the name of a media from the file format, relative to its
+// directory and without extension. The media file and its sidecars share it.
+func (d *Downloader) mediaName(tweet interface{}, kind string, mediaID string, url string) string {
+	t := asTweet(tweet)
+	if t == nil {
+		d.log.Error("Invalid tweet type")
+		return mediaKey(url)
+	}
+	return d.name.expand(d, t, nameMedia{kind: kind, id: mediaID, url: url})
+}
+
+func (tmpl *nameTemplate) expand(d *Downloader, tweet *twitterscraper.Tweet, media nameMedia) string {
+	var width, height int
+	if tmpl.uses["WIDTH"] || tmpl.uses["HEIGHT"] {
+		width, height, _ = d.mediaInfo(tweet.ID, media.id, media.url)
+	}
+	counter := 0
+	if tmpl.uses["COUNTER"] {
+		counter = int(atomic.AddInt64(&d.counter, 1))
+	}
+
+	var b strings.Builder
+	for _, part := range tmpl.parts {
+		if part.field == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		var value string
+		switch part.field {
+		case "DATE":
+			value = time.Unix(tweet.Timestamp, 0).Format(d.opts.DateFormat)
+		case "TIME":
+			value = time.Unix(tweet.Timestamp, 0).Format("15-04-05")
+		case "TIMESTAMP":
+			value = strconv.FormatInt(tweet.Timestamp, 10)
+		case "ID":
+			value = tweet.ID
+		case "USERNAME":
+			value = tweet.Username
+		case "NAME":
+			value = tweet.Name
+		case "TITLE":
+			value = "没有推文"
+			if tweet.Text != "" {
+				value = sanitizeText(tweet.Text, invalidNameRegex, 255)
+			}
+		case "LIKES":
+			value = strconv.Itoa(tweet.Likes)
+		case "RETWEETS":
+			value = strconv.Itoa(tweet.Retweets)
+		case "REPLIES":
+			value = strconv.Itoa(tweet.Replies)
+		case "VIEWS":
+			value = strconv.Itoa(tweet.Views)
+		case "FILENAME":
+			value = mediaKey(media.url)
+		case "MEDIA_ID":
+			value = media.id
+		case "INDEX":
+			value = strconv.Itoa(mediaIndex(tweet, media))
+		case "TYPE":
+			value = media.kind
+		case "EXT":
+			value = mediaExt(media.url)
+			if media.kind == "gif" {
+				value = d.opts.GIFFormat
+			}
+		case "WIDTH":
+			value = strconv.Itoa(width)
+		case "HEIGHT":
+			value = strconv.Itoa(height)
+		case "COUNTER":
+			value = strconv.Itoa(counter)
+		}
+		b.WriteString(part.apply(value))
+	}
+
+	segments := strings.Split(b.String(), "/")
+	for i, segment := range segments {
+		segment = truncateBytes(strings.TrimSpace(segment), maxNameBytes)
+		// A field can expand to . or .., which would leave the output
+		// directory, or start with a dot, which would hide the file.
+		if strings.HasPrefix(segment, ".") {
+			segment = "_" + strings.TrimLeft(segment, ".")
+		}
+		if segment == "" {
+			segment = "_"
+		}
+		segments[i] = segment
+	}
+	return path.Join(segments...)
+}
+
+func (part namePart) apply(value string) string {
+	value = invalidNameRegex.ReplaceAllString(value, "_")
+	if part.truncate > 0 && utf8.RuneCountInString(value) > part.truncate {
+		value = strings.TrimSpace(string([]rune(value)[:part.truncate]))
+	}
+	switch {
+	case part.upper:
+		value = strings.ToUpper(value)
+	case part.lower:
+		value = strings.ToLower(value)
+	}
+	if len(value) < part.pad {
+		value = strings.Repeat("0", part.pad-len(value)) + value
+	}
+	return value
+}
+
+// mediaIndex returns the 1-based position of a media among the photos of the
+// tweet, or among its videos then gifs, as in the /photo/N and /video/N links
+// resolved by selectMedia.
+func mediaIndex(tweet *twitterscraper.Tweet, media nameMedia) int {
+	var ids []string
+	switch media.kind {
+	case "photo":
+		for _, p := range tweet.Photos {
+			if !strings.Contains(p.URL, "video_thumb/") {
+				ids = append(ids, p.ID)
+			}
+		}
+	case "video", "gif":
+		for _, v := range tweet.Videos {
+			ids = append(ids, v.ID)
+		}
+		for _, g := range tweet.GIFs {
+			ids = append(ids, g.ID)
+		}
+	}
+	for i, id := range ids {
+		if id == media.id {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// mediaExt returns the extension of a twimg url, without the dot.
+func mediaExt(url string) string {
+	name := strings.Split(url, "?")[0]
+	name = name[strings.LastIndex(name, "/")+1:]
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return "jpg"
+}
+
+func truncateBytes(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return strings.TrimSpace(s[:max])
+}
+
+func asTweet(tweet interface{}) *twitterscraper.Tweet {
+	switch t := tweet.(type) {
+	case *twitterscraper.TweetResult:
+		return &t.Tweet
+	case *twitterscraper.Tweet:
+		return t
+	}
+	return nil
+}
+
+func sanitizeText(text string, regex *regexp.Regexp, maxLen int) string {
+	// 1. 剔除URL
+	urlRegex := regexp.MustCompile(`https?://[\w\-._~:/?#[\]@!$&'()*+,;=.]+`)
+	text = urlRegex.ReplaceAllString(text, "")
+
+	// 2. 剔除换行符和制表符
+	text = strings.ReplaceAll(text, "\n", " ")
+	text = strings.ReplaceAll(text, "\r", " ")
+	text = strings.ReplaceAll(text, "\t", " ")
+
+	// 3. 剔除连续空格
+	spaceRegex := regexp.MustCompile(`\s+`)
+	text = spaceRegex.ReplaceAllString(text, " ")
+
+	// 4. 剔除emoji
+	emojiRegex := regexp.MustCompile(`[\x{1F600}-\x{1F64F}\x{1F300}-\x{1F5FF}\x{1F680}-\x{1F6FF}\x{1F1E0}-\x{1F1FF}\x{2600}-\x{26FF}\x{2700}-\x{27BF}]`)
+	text = emojiRegex.ReplaceAllString(text, "")
+
+	// 5. 清理剩余特殊字符并限制长度
+	cleaned := ""
+	remaining := maxLen
+	for _, char := range text {
+		charStr := string(char)
+		if regex.MatchString(charStr) {
+			charStr = "_"
+		}
+		if utf8.RuneCountInString(cleaned)+utf8.RuneCountInString(charStr) > remaining {
+			break
+		}
+		cleaned += charStr
+	}
+
+	// 6. 去除首尾空格
+	cleaned = strings.TrimSpace(cleaned)
+
+	// 7. 如果为空，返回默认值
+	if cleaned == "" {
+		return emptyText
+	}
+
+	return cleaned
+}
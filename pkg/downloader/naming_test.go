@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+func TestSanitizeText(t *testing.T) {
+	regex := regexp.MustCompile(`[/\\:*?"<>|]`)
+	for _, c := range []struct {
+		text   string
+		maxLen int
+		want   string
+	}{
+		{"hello https://t.co/abc world", 255, "hello world"},
+		{"a/b:c\nd\te", 255, "a_b_c d e"},
+		{"smile 😀", 255, "smile"},
+		{"https://t.co/abc", 255, "无内容"},
+		{"推文内容很长很长", 4, "推文内容"},
+	} {
+		if got := sanitizeText(c.text, regex, c.maxLen); got != c.want {
+			t.Errorf("sanitizeText(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestMediaName(t *testing.T) {
+	tweet := &twitterscraper.Tweet{
+		ID:        "1",
+		Username:  "user",
+		Name:      "A/B",
+		Text:      "a/b",
+		Likes:     42,
+		Timestamp: 1710000000,
+		Photos:    []twitterscraper.Photo{{ID: "p1", URL: "https://pbs.twimg.com/media/one.jpg"}, {ID: "p2", URL: "https://pbs.twimg.com/media/two.png"}},
+	}
+	date := time.Unix(tweet.Timestamp, 0).Format("20060102")
+	url := "https://pbs.twimg.com/media/two.png?name=orig"
+	for _, c := range []struct {
+		format string
+		want   string
+	}{
+		// Without a field telling the media apart, the file name is appended.
+		{"{DATE}-{USERNAME}-{ID}-{TITLE}", date + "-user-1-a_b_two"},
+		{"", date + "_two_a_b"},
+		{"{username:upper}/{NAME}/{ID}_{INDEX:03}.{TYPE}", "USER/A_B/1_002.photo"},
+		{"{EXT}/{ID}_{MEDIA_ID}_{LIKES}", "png/1_p2_42"},
+		{"{TITLE:1}{FILENAME:lower}", "atwo"},
+		{"{COUNTER:02}_{ID}", "01_1"},
+	} {
+		opts := DefaultOptions()
+		opts.FileFormat = c.format
+		opts.DateFormat = "20060102"
+		d, _, _ := newTestDownloader(t, opts)
+		if got := d.mediaName(tweet, "photo", "p2", url); got != c.want {
+			t.Errorf("%q: mediaName = %q, want %q", c.format, got, c.want)
+		}
+		if got := d.mediaName(&twitterscraper.TweetResult{Tweet: *tweet}, "photo", "p2", url); c.format != "{COUNTER:02}_{ID}" && got != c.want {
+			t.Errorf("%q: mediaName of a TweetResult = %q", c.format, got)
+		}
+	}
+
+	// Gifs are numbered after the videos, as in /video/N links.
+	mixed := &twitterscraper.Tweet{ID: "2", Videos: []twitterscraper.Video{{ID: "v1"}}, GIFs: []twitterscraper.GIF{{ID: "g1"}}}
+	if got := mediaIndex(mixed, nameMedia{kind: "gif", id: "g1"}); got != 2 {
+		t.Errorf("gif index = %d, want 2", got)
+	}
+
+	// Fields made only of dots can't climb out of the output directory.
+	dots := &twitterscraper.Tweet{ID: "1", Text: "..", Timestamp: tweet.Timestamp, Photos: tweet.Photos}
+	for format, want := range map[string]string{"{TITLE}/{ID}_{INDEX}": "_/1_1", "{ID}/{TITLE}_{INDEX}": "1/__1", "{ID}/{TITLE}": "1/__one"} {
+		opts := DefaultOptions()
+		opts.FileFormat = format
+		opts.DateFormat = "20060102"
+		d, _, _ := newTestDownloader(t, opts)
+		if got := d.mediaName(dots, "photo", "p1", "https://pbs.twimg.com/media/one.jpg"); got != want {
+			t.Errorf("%q: mediaName = %q, want %q", format, got, want)
+		}
+	}
+
+	for _, c := range []struct{ format, err string }{
+		{"title", "must contain a field"},
+		{"{DATE}_{SIZE}", "unknown field {SIZE}"},
+		{"{TITLE:short}", `unknown modifier "short"`},
+		{"{ID", "unterminated field"},
+		{"../{ID}", "can't contain"},
+		{"/tmp/{ID}", "relative"},
+	} {
+		opts := DefaultOptions()
+		opts.FileFormat = c.format
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: err = %v, want %q", c.format, err, c.err)
+		}
+	}
+}
+
+func TestDownloadUserFileFormat(t *testing.T) {
+	opts := allOptions()
+	opts.Retweets = true
+	opts.FileFormat = "{USERNAME}/{ID}_{TYPE}{INDEX}"
+	d, _, _ := newTestDownloader(t, opts)
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user")
+	if got := files(t, output+"/img/fixture_user", "1003_photo*.jpg"); len(got) != 2 {
+		t.Errorf("img = %v", got)
+	}
+	// The sidecars have the name of their video.
+	for _, ext := range []string{".mp4", ".jpg", ".nfo", ".ass", ".json"} {
+		if got := files(t, output+"/video/fixture_user", "1002_video1"+ext); len(got) != 1 {
+			t.Errorf("video/fixture_user/1002_video1%s = %v", ext, got)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// mediaPlan is where a media and its sidecars are written. It is computed
+// once per media, before the download is queued, so the thumbnail, NFO, ASS
+// and JSON files of a video always sit next to it with the same name.
+type mediaPlan struct {
+	url    string
+	output string // directory of the user, tweet or list, holding the archive
+	base   string // path of the media without extension
+	ext    string
+
+	path      string
+	converted string // path of a gif converted to GIFFormat, if any
+	thumbnail string
+	nfo       string
+	ass       string
+	json      string
+}
+
+// planMedia names a photo, video or gif of tweet. Media of user timelines
+// (dwn_type "user") go in the img, video or gif directory of output, the
+// others directly in output. rt adds the RE- prefix of retweets.
+func (d *Downloader) planMedia(tweet interface{}, kind string, mediaID string, url string, output string, dwn_type string, rt bool) mediaPlan {
+	name := d.mediaName(tweet, kind, mediaID, url)
+	if rt {
+		name = retweetName(name)
+	}
+	dir := output
+	if dwn_type == "user" {
+		dir = output + "/" + kindDir(kind)
+	}
+	base := dir + "/" + name
+	ext := mediaExt(url)
+	plan := mediaPlan{
+		url:       url,
+		output:    output,
+		base:      base,
+		ext:       ext,
+		path:      base + "." + ext,
+		thumbnail: base + ".jpg",
+		nfo:       base + ".nfo",
+		ass:       base + ".ass",
+		json:      base + ".json",
+	}
+	if kind == "gif" && d.opts.GIFFormat != "mp4" {
+		plan.converted = base + "." + d.opts.GIFFormat
+	}
+	return plan
+}
+
+// exists reports whether the media was already saved, converted or not.
+func (p mediaPlan) exists() bool {
+	for _, path := range []string{p.path, p.converted} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+// retweetName adds the RE- prefix of retweets to the file of a media name.
+func retweetName(name string) string {
+	dir, file := path.Split(name)
+	return dir + "RE-" + file
+}
+
+// kindDir returns the directory of a media kind in a user timeline.
+func kindDir(kind string) string {
+	if kind == "photo" {
+		return "img"
+	}
+	return kind
+}
+
+// makeDir creates the directory of the media and its sidecars.
+func (p mediaPlan) makeDir() {
+	os.MkdirAll(filepath.Dir(p.path), os.ModePerm)
+}
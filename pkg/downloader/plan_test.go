@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+func TestPlanMedia(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FileFormat = "{USERNAME}/{ID}_{INDEX}"
+	d, _, _ := newTestDownloader(t, opts)
+	tweet := &twitterscraper.Tweet{ID: "1", Username: "user", Videos: []twitterscraper.Video{{ID: "v1"}}}
+	url := "https://video.twimg.com/ext_tw_video/v1/pu/vid/720x1280/a.mp4"
+
+	plan := d.planMedia(tweet, "video", "v1", url, "out", "user", true)
+	if plan.path != "out/video/user/RE-1_1.mp4" || plan.output != "out" {
+		t.Errorf("path = %s, output = %s", plan.path, plan.output)
+	}
+	for got, want := range map[string]string{plan.thumbnail: ".jpg", plan.nfo: ".nfo", plan.ass: ".ass", plan.json: ".json"} {
+		if got != "out/video/user/RE-1_1"+want {
+			t.Errorf("sidecar = %s", got)
+		}
+	}
+	if plan := d.planMedia(tweet, "photo", "p1", "https://pbs.twimg.com/media/a.png?name=orig", "out", "tweet", false); plan.path != "out/user/1_1.png" {
+		t.Errorf("photo path = %s", plan.path)
+	}
+}
+
+func TestDownloadRetweetedVideo(t *testing.T) {
+	opts := allOptions()
+	opts.RetweetsOnly = true
+	d, fake, _ := newTestDownloader(t, opts)
+	fake.tweets["1002"].IsRetweet = true
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	// The retweeted video is downloaded once, and its sidecars have its RE-
+	// prefix.
+	output := filepath.Join(d.opts.Output, "fixture_user", "video")
+	if got := files(t, output, "*.mp4"); len(got) != 1 {
+		t.Errorf("video = %v", files(t, output, "*"))
+	}
+	for _, ext := range []string{".mp4", ".jpg", ".nfo", ".ass", ".json"} {
+		if got := files(t, output, "RE-*_video_720_A video"+ext); len(got) != 1 {
+			t.Errorf("video/RE-*%s = %v", ext, files(t, output, "*"))
+		}
+	}
+}
+
+func TestDownloadSkippedVideo(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Videos = true
+	opts.Update = true
+	d, _, media := newTestDownloader(t, opts)
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user")
+	sidecars := func() []string {
+		var found []string
+		for _, ext := range []string{".jpg", ".nfo", ".ass", ".json"} {
+			found = append(found, files(t, output+"/video", "*"+ext)...)
+		}
+		return found
+	}
+	if got := sidecars(); len(got) != 4 {
+		t.Fatalf("sidecars = %v", got)
+	}
+
+	// A video skipped from the archive, then from the disk, keeps its
+	// sidecars as they are.
+	for _, archived := range []bool{true, false} {
+		for _, name := range sidecars() {
+			os.Remove(filepath.Join(output, "video", name))
+		}
+		if !archived {
+			os.Remove(output + "/twmd_archive.jsonl")
+			d.archives = map[string]*downloadArchive{}
+		}
+		requests := media.total()
+		if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+			t.Fatal(err)
+		}
+		if got := sidecars(); len(got) != 0 {
+			t.Errorf("archived %v: sidecars written again: %v", archived, got)
+		}
+		if media.total() != requests {
+			t.Errorf("archived %v: %d media requests", archived, media.total()-requests)
+		}
+	}
+}
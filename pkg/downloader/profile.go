@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// profileSnapshot is saved in profile.json by ProfileAssets.
+type profileSnapshot struct {
+	UserID         string     `json:"user_id"`
+	Username       string     `json:"username"`
+	Name           string     `json:"name"`
+	Biography      string     `json:"biography"`
+	Location       string     `json:"location"`
+	Website        string     `json:"website"`
+	Avatar         string     `json:"avatar"`
+	Banner         string     `json:"banner"`
+	Joined         *time.Time `json:"joined,omitempty"`
+	IsPrivate      bool       `json:"is_private"`
+	IsVerified     bool       `json:"is_verified"`
+	FollowersCount int        `json:"followers_count"`
+	FollowingCount int        `json:"following_count"`
+	TweetsCount    int        `json:"tweets_count"`
+	LikesCount     int        `json:"likes_count"`
+	MediaCount     int        `json:"media_count"`
+	ListedCount    int        `json:"listed_count"`
+	Updated        time.Time  `json:"updated"`
+}
+
+// edited reports whether the profile was edited since old. Counts change
+// all the time and aren't edits.
+func (p *profileSnapshot) edited(old *profileSnapshot) bool {
+	return p.Username != old.Username || p.Name != old.Name || p.Biography != old.Biography ||
+		p.Location != old.Location || p.Website != old.Website || p.Avatar != old.Avatar || p.Banner != old.Banner
+}
+
+func newProfileSnapshot(p *twitterscraper.Profile, now time.Time) *profileSnapshot {
+	return &profileSnapshot{
+		UserID:         p.UserID,
+		Username:       p.Username,
+		Name:           p.Name,
+		Biography:      p.Biography,
+		Location:       p.Location,
+		Website:        p.Website,
+		Avatar:         p.Avatar,
+		Banner:         p.Banner,
+		Joined:         p.Joined,
+		IsPrivate:      p.IsPrivate,
+		IsVerified:     p.IsVerified || p.IsBlueVerified,
+		FollowersCount: p.FollowersCount,
+		FollowingCount: p.FollowingCount,
+		TweetsCount:    p.TweetsCount,
+		LikesCount:     p.LikesCount,
+		MediaCount:     p.MediaCount,
+		ListedCount:    p.ListedCount,
+		Updated:        now,
+	}
+}
+
+// saveProfileAssets saves the avatar, the banner and the profile of a user
+// in output/profile. avatar, banner and profile.json are the current
+// version, and every version is kept with the time it was first seen, as in
+// avatar_2006-01-02_150405.jpg. Failures are logged, they don't stop the
+// crawl.
+func (d *Downloader) saveProfileAssets(name string, output string) {
+	var profile twitterscraper.Profile
+	err := d.retry429(func() (err error) {
+		if err := d.waitForRateLimit(); err != nil {
+			return err
+		}
+		profile, err = d.scraper.GetProfile(name)
+		return err
+	})
+	if err != nil {
+		if d.ctx.Err() == nil {
+			d.log.Errorf("Failed to get the profile of %s: %s", name, err.Error())
+		}
+		return
+	}
+
+	dir := output + "/profile"
+	os.MkdirAll(dir, os.ModePerm)
+	now := d.now()
+	stamp := now.Format("2006-01-02_150405")
+	if profile.Avatar != "" {
+		// The avatar is given in its 48x48 version.
+		d.saveProfileImage(strings.Replace(profile.Avatar, "_normal.", ".", 1), dir, "avatar", stamp)
+	}
+	if profile.Banner != "" {
+		d.saveProfileImage(profile.Banner+"/1500x500", dir, "banner", stamp)
+	}
+
+	snapshot := newProfileSnapshot(&profile, now)
+	js, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		d.log.Errorf("Failed to marshal the profile of %s: %s", name, err.Error())
+		return
+	}
+	current := dir + "/profile.json"
+	old := &profileSnapshot{}
+	previous, err := os.ReadFile(current)
+	if err == nil {
+		json.Unmarshal(previous, old)
+	}
+	if err != nil || snapshot.edited(old) {
+		d.log.Infof("Saving a new version of the profile of %s", name)
+		if err := os.WriteFile(dir+"/profile_"+stamp+".json", js, 0644); err != nil {
+			d.log.Errorf("Failed to save the profile of %s: %s", name, err.Error())
+		}
+	}
+	if err := os.WriteFile(current, js, 0644); err != nil {
+		d.log.Errorf("Failed to save the profile of %s: %s", name, err.Error())
+	}
+}
+
+// saveProfileImage downloads url in dir/NAME.EXT, keeping a dated copy when
+// the image changed.
+func (d *Downloader) saveProfileImage(url string, dir string, name string, stamp string) {
+	if d.opts.URLOnly {
+		d.log.Info(url)
+		return
+	}
+	ext := path.Ext(path.Base(strings.Split(url, "?")[0]))
+	if ext == "" {
+		ext = ".jpg"
+	}
+	current := dir + "/" + name + ext
+	next := dir + "/" + name + ".new" + ext
+	size, sum, err := d.fetchWithRetry("", url, next)
+	if err != nil {
+		if d.ctx.Err() == nil {
+			d.log.Errorf("Failed to download %s: %s", url, err.Error())
+		}
+		return
+	}
+	if old, err := os.ReadFile(current); err == nil {
+		oldSum := sha256.Sum256(old)
+		if hex.EncodeToString(oldSum[:]) == sum {
+			os.Remove(next)
+			return
+		}
+	}
+
+	data, err := os.ReadFile(next)
+	if err == nil {
+		err = os.WriteFile(current, data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(next, dir+"/"+name+"_"+stamp+ext)
+	}
+	if err != nil {
+		d.log.Errorf("Failed to save %s: %s", current, err.Error())
+		return
+	}
+	d.log.Infof("Saved a new %s", name)
+	d.recordDownload("", url, current, size)
+}
@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProfileAssets(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ProfileAssets = true
+	d, fake, media := newTestDownloader(t, opts)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "fixture_user", "profile")
+
+	want := []string{
+		"avatar.jpg", "avatar_2024-03-01_120000.jpg",
+		"banner.jpg", "banner_2024-03-01_120000.jpg",
+		"profile.json", "profile_2024-03-01_120000.json",
+	}
+	got := files(t, output, "*")
+	if len(got) != len(want) {
+		t.Fatalf("profile = %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("profile = %v, want %v", got, want)
+			break
+		}
+	}
+	// The full size avatar is downloaded.
+	if media.count("/profile_images/42/avatar.jpg") != 1 || media.count("/profile_banners/42/1710000000/1500x500") != 1 {
+		t.Error("avatar or banner not downloaded")
+	}
+	avatar, _ := os.ReadFile(filepath.Join(output, "avatar.jpg"))
+	if string(avatar) != mediaContent("/profile_images/42/avatar.jpg") {
+		t.Error("wrong avatar content")
+	}
+	js, err := os.ReadFile(filepath.Join(output, "profile.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot profileSnapshot
+	if err := json.Unmarshal(js, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Biography != "Recorded for the tests" || snapshot.FollowersCount != 100 || snapshot.Joined == nil || snapshot.Joined.Year() != 2020 {
+		t.Errorf("profile = %+v", snapshot)
+	}
+
+	// Counts changing isn't an edit, the same avatar isn't kept twice.
+	now = now.Add(24 * time.Hour)
+	fake.profiles["fixture_user"].FollowersCount = 200
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, output, "*"); len(got) != len(want) {
+		t.Errorf("profile = %v", got)
+	}
+
+	// A new bio and avatar are kept next to the old ones.
+	now = now.Add(24 * time.Hour)
+	fake.profiles["fixture_user"].Biography = "Edited"
+	fake.profiles["fixture_user"].Avatar = media.URL + "/profile_images/42/new_normal.png"
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"profile_2024-03-03_120000.json", "avatar_2024-03-03_120000.png", "avatar.png", "avatar_2024-03-01_120000.jpg"} {
+		if _, err := os.Stat(filepath.Join(output, name)); err != nil {
+			t.Errorf("%s missing: %v", name, files(t, output, "*"))
+		}
+	}
+	if got := files(t, output, "banner_*"); len(got) != 1 {
+		t.Errorf("banner = %v", got)
+	}
+}
+
+func TestProfileAssetsDisabled(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, DefaultOptions())
+	if _, err := d.DownloadUser(context.Background(), "fixture_user"); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range fake.requests {
+		if r == "GetProfile fixture_user" {
+			t.Error("profile fetched without ProfileAssets")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// quoteEntry is a quoted tweet, fetched once per Downloader.
+type quoteEntry struct {
+	once  sync.Once
+	tweet *twitterscraper.Tweet
+	err   error
+}
+
+// quoteSidecar holds the fields added to the JSON of a quoted tweet.
+type quoteSidecar struct {
+	QuotedBy   []string
+	QuoteDepth int
+}
+
+// quotedTweet fetches a quoted tweet with GetTweet. The tweet embedded in
+// the quoting tweet may miss its media.
+func (d *Downloader) quotedTweet(id string) (*twitterscraper.Tweet, bool, error) {
+	d.quotesLock.Lock()
+	entry, ok := d.quotes[id]
+	if !ok {
+		entry = &quoteEntry{}
+		d.quotes[id] = entry
+	}
+	d.quotesLock.Unlock()
+
+	first := false
+	entry.once.Do(func() {
+		first = true
+		entry.tweet, entry.err = d.fetchTweet(id)
+	})
+	if first && entry.err != nil {
+		// Failed fetches are tried again by the next quote.
+		d.quotesLock.Lock()
+		delete(d.quotes, id)
+		d.quotesLock.Unlock()
+	}
+	return entry.tweet, first, entry.err
+}
+
+// followQuote downloads the media of the tweet quoted by quoting in
+// output/quoted, and the tweets it quotes up to QuoteDepth levels. Every
+// quoted tweet gets a QUOTED_ID.json sidecar listing the tweets quoting it.
+func (d *Downloader) followQuote(quoting *twitterscraper.Tweet, output string, depth int) {
+	if !d.opts.FollowQuotes || depth > d.opts.QuoteDepth || quoting == nil || quoting.QuotedStatusID == "" || d.ctx.Err() != nil {
+		return
+	}
+	quoted, first, err := d.quotedTweet(quoting.QuotedStatusID)
+	if err != nil || quoted == nil {
+		if d.ctx.Err() == nil {
+			d.log.Errorf("Failed to fetch tweet %s quoted by %s: %v", quoting.QuotedStatusID, quoting.ID, err)
+		}
+		return
+	}
+
+	dir := output + "/quoted"
+	os.MkdirAll(dir, os.ModePerm)
+	d.saveQuoteJSON(quoted, quoting.ID, dir, depth)
+	if !first {
+		return
+	}
+	d.log.Infof("Following tweet %s quoted by %s", quoted.ID, quoting.ID)
+
+	// Without a media type, everything is downloaded as for a single tweet.
+	all := !d.opts.Videos && !d.opts.Images && !d.opts.GIFs
+	if all || d.opts.Videos {
+		d.videoSingle(quoted, dir, false)
+	}
+	if all || d.opts.Images {
+		d.photoSingle(quoted, dir, false)
+	}
+	if all || d.opts.GIFs {
+		d.gifSingle(quoted, dir, false)
+	}
+	d.followQuote(quoted, output, depth+1)
+}
+
+// saveQuoteJSON saves the quoted tweet in dir/QUOTED_ID.json, adding
+// quotingID to the QuotedBy list of a previous run.
+func (d *Downloader) saveQuoteJSON(quoted *twitterscraper.Tweet, quotingID string, dir string, depth int) {
+	path := dir + "/" + quoted.ID + ".json"
+	d.quotesLock.Lock()
+	defer d.quotesLock.Unlock()
+
+	var sidecar quoteSidecar
+	js, err := os.ReadFile(path)
+	if err == nil {
+		json.Unmarshal(js, &sidecar)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		d.log.Errorf("Failed to read %s: %s", path, err.Error())
+	}
+	for _, id := range sidecar.QuotedBy {
+		if id == quotingID {
+			return
+		}
+	}
+	sidecar.QuotedBy = append(sidecar.QuotedBy, quotingID)
+	if sidecar.QuoteDepth == 0 || depth < sidecar.QuoteDepth {
+		sidecar.QuoteDepth = depth
+	}
+
+	js, err = json.Marshal(quoted)
+	if err == nil {
+		js, err = appendJSONField(js, "QuotedBy", sidecar.QuotedBy)
+	}
+	if err == nil {
+		js, err = appendJSONField(js, "QuoteDepth", sidecar.QuoteDepth)
+	}
+	if err != nil {
+		d.log.Errorf("Failed to marshal tweet to JSON: %s", err.Error())
+		return
+	}
+	if err := os.WriteFile(path, js, 0644); err != nil {
+		d.log.Errorf("Failed to save %s: %s", path, err.Error())
+	}
+}
@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFollowQuotes(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FollowQuotes = true
+	d, fake, _ := newTestDownloader(t, opts)
+	result, err := d.DownloadTweet(context.Background(), "9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoted := filepath.Join(d.opts.Output, "quoted")
+	if len(result.Downloaded) != 2 {
+		t.Errorf("Downloaded = %v", result.Downloaded)
+	}
+	if got := files(t, quoted, "*quoted*.jpg"); len(got) != 1 {
+		t.Errorf("quoted = %v", files(t, quoted, "*"))
+	}
+	// QuoteDepth is 1, the nested quote isn't followed.
+	if got := files(t, quoted, "*.mp4"); len(got) != 0 {
+		t.Errorf("nested quote downloaded: %v", got)
+	}
+
+	// A second quote of the same tweet is linked in its sidecar, without
+	// fetching it again.
+	if _, err := d.DownloadTweet(context.Background(), "9003"); err != nil {
+		t.Fatal(err)
+	}
+	js, err := os.ReadFile(filepath.Join(quoted, "9001.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sidecar struct {
+		ID         string
+		QuotedBy   []string
+		QuoteDepth int
+	}
+	if err := json.Unmarshal(js, &sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.ID != "9001" || strings.Join(sidecar.QuotedBy, ",") != "9000,9003" || sidecar.QuoteDepth != 1 {
+		t.Errorf("sidecar = %+v", sidecar)
+	}
+	n := 0
+	for _, r := range fake.requests {
+		if r == "GetTweet 9001" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("quoted tweet fetched %d times", n)
+	}
+}
+
+func TestFollowQuotesDepth(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FollowQuotes = true
+	opts.QuoteDepth = 3
+	d, _, _ := newTestDownloader(t, opts)
+	if _, err := d.DownloadTweet(context.Background(), "9000"); err != nil {
+		t.Fatal(err)
+	}
+	quoted := filepath.Join(d.opts.Output, "quoted")
+	if got := files(t, quoted, "*nested*.mp4"); len(got) != 1 {
+		t.Errorf("quoted = %v", files(t, quoted, "*"))
+	}
+	js, _ := os.ReadFile(filepath.Join(quoted, "9002.json"))
+	if !strings.Contains(string(js), `"QuotedBy":["9001"],"QuoteDepth":2`) {
+		t.Errorf("9002.json = %s", js)
+	}
+}
+
+func TestFollowQuotesDisabled(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, DefaultOptions())
+	if _, err := d.DownloadTweet(context.Background(), "9000"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 1 || len(files(t, d.opts.Output, "quoted")) != 0 {
+		t.Errorf("requests = %v", fake.requests)
+	}
+}
@@ -0,0 +1,139 @@
+package downloader
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+func randomDuration(minSec, maxSec int) time.Duration {
+	sec := minSec + rand.Intn(maxSec-minSec+1)
+	return time.Duration(sec) * time.Second
+}
+
+// sleepContext waits for duration, or until ctx is cancelled.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (d *Downloader) waitForRateLimit() error {
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	d.requestCountLock.Lock()
+	defer d.requestCountLock.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(d.lastMinuteReset) >= time.Minute {
+		d.requestCount = 0
+		d.lastMinuteReset = now
+	}
+
+	if d.requestCount >= 60 {
+		waitTime := time.Minute - now.Sub(d.lastMinuteReset)
+		d.log.Warnf("Rate limit reached (60 requests/min), waiting %v", waitTime)
+		if err := d.sleep(d.ctx, waitTime); err != nil {
+			return err
+		}
+		d.requestCount = 0
+		d.lastMinuteReset = time.Now()
+	}
+
+	waitTime := time.Duration(1+rand.Intn(3)) * time.Second
+	if !d.lastRequestTime.IsZero() {
+		elapsed := now.Sub(d.lastRequestTime)
+		if elapsed < waitTime {
+			if err := d.sleep(d.ctx, waitTime-elapsed); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.requestCount++
+	d.lastRequestTime = time.Now()
+	return nil
+}
+
+// handle429Error cools down after a 429 error. It returns false when the
+// request shouldn't be retried.
+func (d *Downloader) handle429Error() bool {
+	d.requestCountLock.Lock()
+	d.consecutive429Count++
+	d.requestCountLock.Unlock()
+
+	if d.consecutive429Count == 1 {
+		waitTime := time.Duration(3+rand.Intn(3)) * time.Minute
+		d.log.Warnf("429 Too Many Requests detected. Cooling down for %v (first offense)", waitTime)
+		d.isCoolingDown = true
+		d.coolingDownStart = time.Now()
+		err := d.sleep(d.ctx, waitTime)
+		d.isCoolingDown = false
+		return err == nil
+	} else if d.consecutive429Count >= 2 {
+		waitTime := time.Duration(10+rand.Intn(6)) * time.Minute
+		d.log.Warnf("429 Too Many Requests detected again. Extended cooling down for %v (offense #%d)", waitTime, d.consecutive429Count)
+		d.isCoolingDown = true
+		d.coolingDownStart = time.Now()
+		err := d.sleep(d.ctx, waitTime)
+		d.isCoolingDown = false
+		return err == nil
+	}
+	return false
+}
+
+// is429 reports whether err is a rate limit error of the API.
+func is429(err error) bool {
+	return strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "Too Many Requests")
+}
+
+// retry429 calls request until it doesn't fail with a 429, cooling down
+// after each one, for at most MaxRetries attempts. It returns the last error.
+func (d *Downloader) retry429(request func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := request()
+		if err == nil {
+			d.reset429Count()
+			return nil
+		}
+		if !is429(err) || attempt >= d.opts.MaxRetries || !d.handle429Error() {
+			return err
+		}
+	}
+}
+
+func (d *Downloader) reset429Count() {
+	d.requestCountLock.Lock()
+	d.consecutive429Count = 0
+	d.requestCountLock.Unlock()
+}
+
+func (d *Downloader) checkAndPauseForBatch() {
+	d.requestCountLock.Lock()
+	d.tweetCount++
+	currentCount := d.tweetCount
+	d.requestCountLock.Unlock()
+
+	if currentCount > 0 && currentCount%d.batchPauseCount == 0 {
+		waitTime := time.Duration(2+rand.Intn(2)) * time.Minute
+		d.log.Infof("Processed %d tweets, pausing for %v to avoid rate limit", currentCount, waitTime)
+		d.sleep(d.ctx, waitTime)
+	}
+}
+
+func (d *Downloader) getRetryWaitTime(retryCount int) time.Duration {
+	waitTime := d.opts.RetryWait * time.Duration(1<<retryCount)
+	if d.opts.RetryJitter <= 0 {
+		return waitTime
+	}
+	randomAdd := time.Duration(rand.Int63n(int64(d.opts.RetryJitter)))
+	return waitTime + randomAdd
+}
@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+var searchDirRegex = regexp.MustCompile(`[/\\:*?"<>|]`)
+
+// searchDir is the directory name of the results of a search query. Queries
+// made only of dots, urls or emoji are told apart by a hash.
+func searchDir(query string) string {
+	name := strings.TrimLeft(sanitizeText(query, searchDirRegex, 100), ". ")
+	if name == "" || name == emptyText && strings.TrimSpace(query) != emptyText {
+		sum := sha256.Sum256([]byte(query))
+		return "query_" + hex.EncodeToString(sum[:4])
+	}
+	return name
+}
+
+// DownloadSearch crawls the results of a search query in the SearchTab tab
+// and downloads their media in Output/search/QUERY. The scraper must be
+// logged in.
+func (d *Downloader) DownloadSearch(ctx context.Context, query string) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	// The scraper has no media tab, the latest media tweets are searched
+	// instead.
+	searchQuery := query
+	switch d.opts.SearchTab {
+	case "top":
+		d.scraper.SetSearchMode(twitterscraper.SearchTop)
+	case "latest":
+		d.scraper.SetSearchMode(twitterscraper.SearchLatest)
+	case "media":
+		d.scraper.SetSearchMode(twitterscraper.SearchLatest)
+		searchQuery += " filter:media"
+	}
+	return result, d.downloadTimeline(ctx, timeline{
+		name:   query,
+		query:  searchQuery,
+		output: d.opts.Output + "/search/" + searchDir(query),
+		fetch:  d.scraper.FetchSearchTweets,
+		// Top results are ranked, not sorted.
+		ordered: d.opts.SearchTab != "top",
+	})
+}
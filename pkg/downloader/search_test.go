@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+func TestDownloadSearch(t *testing.T) {
+	opts := allOptions()
+	opts.SearchTab = "latest"
+	d, fake, _ := newTestDownloader(t, opts)
+	d.SetAuthToken("token", "ct0")
+	result, err := d.DownloadSearch(context.Background(), "#photo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.searchMode != twitterscraper.SearchLatest || fake.requests[0] != "FetchSearchTweets 1 #photo " {
+		t.Errorf("requests = %q", fake.requests)
+	}
+	if result.Tweets != 0 {
+		t.Errorf("Tweets = %d", result.Tweets)
+	}
+
+	result, err = d.DownloadSearch(context.Background(), "photo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The retweet matches too, but Retweets isn't set.
+	output := filepath.Join(d.opts.Output, "search", "photo")
+	if result.Tweets != 2 || len(result.Downloaded) != 2 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if got := files(t, output+"/img", "*"); len(got) != 2 {
+		t.Errorf("img = %v", got)
+	}
+}
+
+func TestDownloadSearchMedia(t *testing.T) {
+	opts := allOptions()
+	opts.SearchTab = "media"
+	opts.SinceID = "1001"
+	d, fake, _ := newTestDownloader(t, opts)
+	d.SetAuthToken("token", "ct0")
+	result, err := d.DownloadSearch(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(fake.requests[0], "FetchSearchTweets 1 a filter:media") {
+		t.Errorf("requests = %q", fake.requests)
+	}
+	// Latest results are sorted, SinceID stops the crawl.
+	if result.Tweets != 2 {
+		t.Errorf("Tweets = %d, want 2", result.Tweets)
+	}
+	if got := files(t, filepath.Join(d.opts.Output, "search", "a", "video"), "*.mp4"); len(got) != 1 {
+		t.Errorf("video = %v", got)
+	}
+}
+
+func TestSearchTab(t *testing.T) {
+	opts := DefaultOptions()
+	opts.SearchTab = "people"
+	if _, err := New(opts); err == nil {
+		t.Error("New should reject an unknown search tab")
+	}
+}
+
+func TestSearchDir(t *testing.T) {
+	for query, want := range map[string]string{
+		"#golang":         "#golang",
+		"cats from:user":  "cats from_user",
+		"../../etc":       "_.._etc",
+		"a/b https://x.y": "a_b",
+		"无内容":             "无内容",
+	} {
+		if got := searchDir(query); got != want {
+			t.Errorf("searchDir(%q) = %q, want %q", query, got, want)
+		}
+	}
+
+	// Queries left empty by the sanitizer get a directory of their own.
+	seen := map[string]bool{}
+	for _, query := range []string{"..", "https://x.y", "https://z.w", "😀"} {
+		got := searchDir(query)
+		if !strings.HasPrefix(got, "query_") || seen[got] {
+			t.Errorf("searchDir(%q) = %q", query, got)
+		}
+		seen[got] = true
+	}
+}
@@ -0,0 +1,265 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// generateNFOFile writes the Jellyfin metadata of a video at plan.nfo.
+func (d *Downloader) generateNFOFile(tweet interface{}, plan mediaPlan) {
+	// Extract tweet information
+	var title, description, author, date string
+	var tweetID string
+
+	// Log tweet processing
+	switch t := tweet.(type) {
+	case *twitterscraper.TweetResult:
+		tweetID = t.ID
+	case *twitterscraper.Tweet:
+		tweetID = t.ID
+	}
+	d.log.Infof("Processing tweet: %s", tweetID)
+
+	switch t := tweet.(type) {
+	case *twitterscraper.TweetResult:
+		if t.Text != "" {
+			description = t.Text
+		} else {
+			description = "没有推文"
+		}
+		title = t.Name + "的推文"
+		author = t.Username
+		date = time.Unix(t.Timestamp, 0).Format("2006-01-02")
+		tweetID = t.ID
+	case *twitterscraper.Tweet:
+		if t.Text != "" {
+			description = t.Text
+		} else {
+			description = "没有推文"
+		}
+		title = t.Name + "的推文"
+		author = t.Username
+		date = time.Unix(t.Timestamp, 0).Format("2006-01-02")
+		tweetID = t.ID
+	}
+
+	nfoPath := plan.nfo
+	nfoName := filepath.Base(nfoPath)
+	plan.makeDir()
+
+	// Generate nfo content (Jellyfin compatible XML)
+	nfoContent := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<movie>
+  <title>%s</title>
+  <originaltitle>%s</originaltitle>
+  <plot>%s</plot>
+  <outline>%s</outline>
+  <year>%s</year>
+  <premiered>%s</premiered>
+  <aired>%s</aired>
+  <studio>Twitter</studio>
+  <director>%s</director>
+  <credits>%s</credits>
+  <actor>
+    <name>%s</name>
+    <role>作者</role>
+  </actor>
+  <tag>Twitter</tag>
+  <tag>视频</tag>
+  <uniqueid type="twitter" default="true">%s</uniqueid>
+</movie>`,
+		title, title, description, description, date[:4], date, date, author, author, author, tweetID)
+
+	// Write nfo file
+	err := os.WriteFile(nfoPath, []byte(nfoContent), 0644)
+	if err == nil {
+		d.log.Infof("Generated NFO file: %s", nfoName)
+		d.log.Infof("NFO file path: %s", nfoPath)
+	} else {
+		d.log.Errorf("Failed to generate NFO file: %s", err.Error())
+	}
+}
+
+// generateASSFile writes a subtitle with the text of the tweet at plan.ass.
+func (d *Downloader) generateASSFile(tweet interface{}, plan mediaPlan) {
+	// Get tweet content for subtitle
+	tweetContent := "没有推文"
+	pattern := `[/\\:*?\"<>|]`
+	regex, _ := regexp.Compile(pattern)
+
+	switch t := tweet.(type) {
+	case *twitterscraper.TweetResult:
+		if t.Text != "" {
+			tweetContent = sanitizeText(t.Text, regex, 20)
+		}
+	case *twitterscraper.Tweet:
+		if t.Text != "" {
+			tweetContent = sanitizeText(t.Text, regex, 20)
+		}
+	}
+
+	assPath := plan.ass
+	assName := filepath.Base(assPath)
+	plan.makeDir()
+
+	// Generate ass content
+	assContent := fmt.Sprintf(`[Script Info]
+; Script generated by twmd
+Title: Twitter Video Subtitle
+Original Script: twmd
+ScriptType: v4.00+
+Collisions: Normal
+PlayResX: 1080
+PlayResY: 1920
+WrapStyle: 3
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000099,0,0,0,0,100,100,0,0,1,2,2,2,40,40,80,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:00.00,99:59:59.99,Default,,40,40,80,,%s`,
+		tweetContent)
+
+	// Write ass file
+	err := os.WriteFile(assPath, []byte(assContent), 0644)
+	if err == nil {
+		d.log.Infof("Generated ASS file: %s", assName)
+		d.log.Infof("ASS file path: %s", assPath)
+	} else {
+		d.log.Errorf("Failed to generate ASS file: %s", err.Error())
+	}
+}
+
+// saveTweetJSON writes the tweet, with the downloaded variant of its video,
+// at plan.json.
+func (d *Downloader) saveTweetJSON(tweet interface{}, variant *videoVariant, plan mediaPlan) {
+	jsonPath := plan.json
+	jsonName := filepath.Base(jsonPath)
+	plan.makeDir()
+
+	// Marshal tweet to JSON, with the downloaded variant of the video
+	tweetJSON, err := json.Marshal(tweet)
+	if err == nil && variant != nil {
+		tweetJSON, err = appendJSONField(tweetJSON, "Variant", variant)
+	}
+	if err != nil {
+		d.log.Errorf("Failed to marshal tweet to JSON: %s", err.Error())
+		return
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, tweetJSON, "", "  "); err == nil {
+		tweetJSON = indented.Bytes()
+	}
+
+	// Write JSON file
+	err = os.WriteFile(jsonPath, tweetJSON, 0644)
+	if err == nil {
+		d.log.Infof("Saved tweet JSON: %s", jsonName)
+		d.log.Infof("JSON file path: %s", jsonPath)
+	} else {
+		d.log.Errorf("Failed to save tweet JSON: %s", err.Error())
+	}
+}
+
+// appendJSONField adds a field to a marshalled JSON object.
+func appendJSONField(object []byte, key string, value interface{}) ([]byte, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	k, _ := json.Marshal(key)
+	object = bytes.TrimRight(object, " \n")
+	if !bytes.HasSuffix(object, []byte("}")) {
+		return nil, errors.New("not a JSON object")
+	}
+	object = object[:len(object)-1]
+	if !bytes.HasSuffix(bytes.TrimSpace(object), []byte("{")) {
+		object = append(object, ',')
+	}
+	object = append(object, k...)
+	object = append(object, ':')
+	object = append(object, js...)
+	return append(object, '}'), nil
+}
+
+// downloadThumbnail saves the preview image of a video at plan.thumbnail.
+func (d *Downloader) downloadThumbnail(wg *sync.WaitGroup, tweet interface{}, video interface{}, plan mediaPlan) {
+	videoUrl := plan.url
+	defer wg.Done()
+
+	// Log thumbnail download start
+	d.log.Infof("Starting thumbnail download")
+	d.log.Infof("Video URL: %s", videoUrl)
+	d.log.Infof("Output directory: %s", plan.output)
+
+	// Extract thumbnail URL from video object
+	var thumbnailUrl string
+
+	// First, try to get thumbnail URL from video object using reflection
+	v := reflect.ValueOf(video)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	// Try to get Preview field from video object
+	previewField := v.FieldByName("Preview")
+	if previewField.IsValid() {
+		// Check if Preview is a struct with URL field
+		if previewField.Kind() == reflect.Struct {
+			urlField := previewField.FieldByName("URL")
+			if urlField.IsValid() && urlField.Kind() == reflect.String {
+				thumbnailUrl = urlField.String()
+			}
+		} else if previewField.Kind() == reflect.String {
+			// Check if Preview is directly a string URL
+			thumbnailUrl = previewField.String()
+		}
+	}
+
+	// If no Preview field found, try PreviewURL field
+	if thumbnailUrl == "" {
+		previewURLField := v.FieldByName("PreviewURL")
+		if previewURLField.IsValid() && previewURLField.Kind() == reflect.String {
+			thumbnailUrl = previewURLField.String()
+		}
+	}
+
+	if thumbnailUrl == "" {
+		d.log.Errorf("No Preview field found in video object for: %s", videoUrl)
+		return
+	}
+
+	// Ensure thumbnail URL is valid
+	if !strings.HasPrefix(thumbnailUrl, "http") {
+		d.log.Errorf("Invalid thumbnail URL: %s", thumbnailUrl)
+		return
+	}
+
+	d.log.Infof("Trying to download thumbnail from: %s", thumbnailUrl)
+
+	// Save thumbnail next to the video
+	thumbnailPath := plan.thumbnail
+	thumbnailName := filepath.Base(thumbnailPath)
+	plan.makeDir()
+
+	d.log.Infof("Thumbnail download started")
+	if _, _, err := d.fetchWithRetry(tweetID(tweet), thumbnailUrl, thumbnailPath); err != nil {
+		d.log.Errorf("Error downloading thumbnail: %s", err.Error())
+		return
+	}
+	d.log.Infof("Downloaded thumbnail: %s", thumbnailName)
+}
@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// crawlState is the pagination checkpoint of a timeline crawl. It is saved
+// after every page so an interrupted crawl can be continued with Resume.
+type crawlState struct {
+	User          string    `json:"user"`
+	Cursor        string    `json:"cursor"`
+	LastTweetID   string    `json:"last_tweet_id"`
+	NewestTweetID string    `json:"newest_tweet_id"`
+	ProcessedIDs  []string  `json:"processed_ids,omitempty"`
+	Updated       time.Time `json:"updated"`
+
+	// recordIDs adds the tweets of every checkpointed page to ProcessedIDs.
+	recordIDs bool
+}
+
+func loadState(path string) (*crawlState, error) {
+	state := &crawlState{}
+	js, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, json.Unmarshal(js, state)
+}
+
+// addProcessed records ids in ProcessedIDs, once.
+func (state *crawlState) addProcessed(ids []string) {
+	seen := make(map[string]bool, len(state.ProcessedIDs))
+	for _, id := range state.ProcessedIDs {
+		seen[id] = true
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			state.ProcessedIDs = append(state.ProcessedIDs, id)
+		}
+	}
+}
+
+func (state *crawlState) save(path string) error {
+	state.Updated = time.Now()
+	js, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", js, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// compareIDs compares two numeric tweet ids.
+func compareIDs(a, b string) int {
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// crawlBounds limits a crawl to the tweets posted in [since, until). Timelines
+// ordered from the newest tweet stop at sinceID and at the first tweet older
+// than since; the other tweets out of bounds are skipped.
+type crawlBounds struct {
+	sinceID string
+	since   time.Time
+	until   time.Time
+	ordered bool
+}
+
+// skip reports whether tweet is out of bounds, and whether the crawl should
+// stop there. Pinned tweets never stop a crawl, and are kept by sinceID.
+func (b crawlBounds) skip(tweet *twitterscraper.Tweet) (skip bool, stop bool) {
+	posted := time.Unix(tweet.Timestamp, 0)
+	if b.sinceID != "" && !tweet.IsPin && compareIDs(tweet.ID, b.sinceID) <= 0 {
+		return true, b.ordered
+	}
+	if !b.since.IsZero() && posted.Before(b.since) {
+		return true, b.ordered && !tweet.IsPin
+	}
+	if !b.until.IsZero() && !posted.Before(b.until) {
+		return true, false
+	}
+	return false, false
+}
+
+type fetchTweetFunc func(query string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error)
+
+// crawlTimeline pages through a timeline starting at state.Cursor and calls
+// handle for every tweet within bounds passing the filters. The state is
+// checkpointed once all the downloads of a page are finished.
+func (d *Downloader) crawlTimeline(ctx context.Context, query string, maxTweetsNbr int, bounds crawlBounds, fetch fetchTweetFunc, state *crawlState, statePath string, handle func(*sync.WaitGroup, *twitterscraper.TweetResult)) error {
+	cursor := state.Cursor
+	count := 0
+	for count < maxTweetsNbr {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var tweets []*twitterscraper.Tweet
+		var next string
+		err := d.retry429(func() (err error) {
+			tweets, next, err = fetch(query, maxTweetsNbr, cursor)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("error fetching tweets: %w", err)
+		}
+		if len(tweets) == 0 {
+			break
+		}
+
+		wg := sync.WaitGroup{}
+		reachedSince := false
+		skipped := 0
+		var processed []string
+		lastID, newestID := state.LastTweetID, state.NewestTweetID
+		for _, tweet := range tweets {
+			if count >= maxTweetsNbr || ctx.Err() != nil {
+				break
+			}
+			if skip, stop := bounds.skip(tweet); stop {
+				d.log.Infof("Reached tweet %s, older than --since-id or --since, stopping", tweet.ID)
+				reachedSince = true
+				break
+			} else if skip {
+				skipped++
+				continue
+			}
+			if ok, reason := d.filter.match(tweet); !ok {
+				d.log.Debugf("Skipping tweet %s: %s", tweet.ID, reason)
+				skipped++
+				continue
+			}
+			if d.waitForRateLimit() != nil {
+				break
+			}
+			d.checkAndPauseForBatch()
+			handle(&wg, &twitterscraper.TweetResult{Tweet: *tweet})
+
+			count++
+			processed = append(processed, tweet.ID)
+			d.resultLock.Lock()
+			d.result.Tweets++
+			d.resultLock.Unlock()
+			lastID = tweet.ID
+			if !tweet.IsPin && (newestID == "" || compareIDs(tweet.ID, newestID) > 0) {
+				newestID = tweet.ID
+			}
+		}
+		wg.Wait()
+
+		// The downloads of an interrupted page may be incomplete, it is
+		// crawled again by the next run.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		state.LastTweetID, state.NewestTweetID = lastID, newestID
+		if state.recordIDs {
+			state.addProcessed(processed)
+		}
+
+		// A partially processed page is fetched again on resume.
+		if len(processed)+skipped == len(tweets) {
+			state.Cursor = next
+		}
+		if err := state.save(statePath); err != nil {
+			d.log.Errorf("Failed to save state: %s", err.Error())
+		}
+		if reachedSince || next == "" || next == cursor {
+			break
+		}
+		cursor = next
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TargetKind is what a Target points to.
+type TargetKind int
+
+const (
+	TargetTweet TargetKind = iota
+	TargetUser
+	TargetList
+)
+
+func (k TargetKind) String() string {
+	switch k {
+	case TargetTweet:
+		return "tweet"
+	case TargetUser:
+		return "user"
+	case TargetList:
+		return "list"
+	}
+	return "unknown"
+}
+
+// Target is a tweet, a user or a list given by id, username or link.
+type Target struct {
+	Kind TargetKind
+	// ID is the id of a tweet or a list.
+	ID       string
+	Username string
+	// MediaType and MediaIndex select a single media of a tweet, from links
+	// ending in /photo/2 or /video/1. MediaIndex starts at 1, 0 is every
+	// media.
+	MediaType  string
+	MediaIndex int
+}
+
+var (
+	idRegex       = regexp.MustCompile(`^\d+$`)
+	usernameRegex = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+)
+
+// targetHosts are the sites whose links are accepted, with or without a
+// www., mobile., m. or d. prefix.
+var targetHosts = map[string]bool{
+	"twitter.com":   true,
+	"x.com":         true,
+	"fxtwitter.com": true,
+	"vxtwitter.com": true,
+	"fixupx.com":    true,
+	"fixvx.com":     true,
+}
+
+// reservedPaths are first path segments which aren't usernames.
+var reservedPaths = map[string]bool{
+	"home":          true,
+	"explore":       true,
+	"search":        true,
+	"i":             true,
+	"settings":      true,
+	"messages":      true,
+	"notifications": true,
+	"compose":       true,
+	"intent":        true,
+	"share":         true,
+	"hashtag":       true,
+	"login":         true,
+	"logout":        true,
+	"tos":           true,
+	"privacy":       true,
+}
+
+// ParseTarget resolves a tweet id, a username or a tweet, profile or list
+// link. A bare number is a tweet id, as given to -t.
+func ParseTarget(s string) (Target, error) {
+	s = strings.TrimSpace(s)
+	if idRegex.MatchString(s) {
+		return Target{Kind: TargetTweet, ID: s}, nil
+	}
+	if name := strings.TrimPrefix(s, "@"); usernameRegex.MatchString(name) {
+		return Target{Kind: TargetUser, Username: name}, nil
+	}
+
+	raw := s
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Target{}, fmt.Errorf("invalid target %q, expected a tweet id, a username or a link", s)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, prefix := range []string{"www.", "mobile.", "m.", "d."} {
+		host = strings.TrimPrefix(host, prefix)
+	}
+	if !targetHosts[host] {
+		return Target{}, fmt.Errorf("invalid target %q, %s links aren't supported", s, host)
+	}
+
+	var parts []string
+	for _, p := range strings.Split(u.Path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return Target{}, fmt.Errorf("invalid target %q, the link has no tweet, user or list", s)
+	}
+
+	if parts[0] == "i" {
+		switch {
+		case len(parts) >= 3 && parts[1] == "lists" && idRegex.MatchString(parts[2]):
+			return Target{Kind: TargetList, ID: parts[2]}, nil
+		case len(parts) >= 3 && parts[1] == "status" && idRegex.MatchString(parts[2]):
+			return tweetTarget(s, parts[2], parts[3:])
+		case len(parts) >= 4 && parts[1] == "web" && parts[2] == "status" && idRegex.MatchString(parts[3]):
+			return tweetTarget(s, parts[3], parts[4:])
+		}
+		return Target{}, fmt.Errorf("invalid target %q, the link has no tweet, user or list", s)
+	}
+
+	name := strings.TrimPrefix(parts[0], "@")
+	if reservedPaths[strings.ToLower(name)] || !usernameRegex.MatchString(name) {
+		return Target{}, fmt.Errorf("invalid target %q, the link has no tweet, user or list", s)
+	}
+	if len(parts) >= 3 && (parts[1] == "status" || parts[1] == "statuses") {
+		if !idRegex.MatchString(parts[2]) {
+			return Target{}, fmt.Errorf("invalid target %q, bad tweet id %q", s, parts[2])
+		}
+		target, err := tweetTarget(s, parts[2], parts[3:])
+		if err != nil {
+			return Target{}, err
+		}
+		target.Username = name
+		return target, nil
+	}
+	// Tabs of a profile, like /media or /with_replies, are the user.
+	return Target{Kind: TargetUser, Username: name}, nil
+}
+
+// tweetTarget returns the tweet id, with the media selected by the rest of
+// the path, as in photo/2.
+func tweetTarget(s string, id string, rest []string) (Target, error) {
+	target := Target{Kind: TargetTweet, ID: id}
+	if len(rest) < 2 || (rest[0] != "photo" && rest[0] != "video") {
+		return target, nil
+	}
+	index, err := strconv.Atoi(rest[1])
+	if err != nil || index < 1 {
+		return Target{}, fmt.Errorf("invalid target %q, bad media index %q", s, rest[1])
+	}
+	target.MediaType = rest[0]
+	target.MediaIndex = index
+	return target, nil
+}
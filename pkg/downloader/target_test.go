@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Target
+	}{
+		{"156170319961391104", Target{Kind: TargetTweet, ID: "156170319961391104"}},
+		{"Spraytrains", Target{Kind: TargetUser, Username: "Spraytrains"}},
+		{" @Spraytrains ", Target{Kind: TargetUser, Username: "Spraytrains"}},
+		{"https://twitter.com/Spraytrains/status/156170319961391104", Target{Kind: TargetTweet, ID: "156170319961391104", Username: "Spraytrains"}},
+		{"https://x.com/Spraytrains/status/156170319961391104?s=20&t=abc", Target{Kind: TargetTweet, ID: "156170319961391104", Username: "Spraytrains"}},
+		{"http://mobile.twitter.com/Spraytrains/statuses/123", Target{Kind: TargetTweet, ID: "123", Username: "Spraytrains"}},
+		{"x.com/Spraytrains/status/123", Target{Kind: TargetTweet, ID: "123", Username: "Spraytrains"}},
+		{"https://fxtwitter.com/Spraytrains/status/123/photo/2", Target{Kind: TargetTweet, ID: "123", Username: "Spraytrains", MediaType: "photo", MediaIndex: 2}},
+		{"https://vxtwitter.com/Spraytrains/status/123/video/1", Target{Kind: TargetTweet, ID: "123", Username: "Spraytrains", MediaType: "video", MediaIndex: 1}},
+		{"https://d.fxtwitter.com/Spraytrains/status/123/", Target{Kind: TargetTweet, ID: "123", Username: "Spraytrains"}},
+		{"https://x.com/i/status/123", Target{Kind: TargetTweet, ID: "123"}},
+		{"https://twitter.com/i/web/status/123", Target{Kind: TargetTweet, ID: "123"}},
+		{"https://www.x.com/Spraytrains", Target{Kind: TargetUser, Username: "Spraytrains"}},
+		{"https://x.com/Spraytrains/media", Target{Kind: TargetUser, Username: "Spraytrains"}},
+		{"https://x.com/i/lists/1234567890", Target{Kind: TargetList, ID: "1234567890"}},
+		{"https://twitter.com/i/lists/1234567890/members", Target{Kind: TargetList, ID: "1234567890"}},
+	}
+	for _, test := range tests {
+		got, err := ParseTarget(test.in)
+		if err != nil {
+			t.Errorf("ParseTarget(%q): %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseTarget(%q) = %+v, want %+v", test.in, got, test.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"not a username",
+		"https://example.com/Spraytrains/status/123",
+		"https://x.com/",
+		"https://x.com/home",
+		"https://x.com/search?q=sunset",
+		"https://x.com/i/bookmarks",
+		"https://x.com/Spraytrains/status/abc",
+		"https://x.com/Spraytrains/status/123/photo/0",
+		"ftp://x.com/Spraytrains",
+	} {
+		if got, err := ParseTarget(in); err == nil {
+			t.Errorf("ParseTarget(%q) = %+v, want an error", in, got)
+		}
+	}
+}
+
+func TestDownloadTweetMedia(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, DefaultOptions())
+	result, err := d.DownloadTweetMedia(context.Background(), "1003", "photo", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Downloaded) != 1 || !strings.HasSuffix(result.Downloaded[0].URL, "/media/photo_b.jpg?name=orig") {
+		t.Errorf("Downloaded = %v", result.Downloaded)
+	}
+	if got := files(t, d.opts.Output, "*.jpg"); len(got) != 1 {
+		t.Errorf("files = %v", got)
+	}
+
+	if _, err := d.DownloadTweetMedia(context.Background(), "1003", "photo", 3); err == nil || !strings.Contains(err.Error(), "has no photo 3") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := d.DownloadTweetMedia(context.Background(), "1003", "video", 1); err == nil {
+		t.Error("DownloadTweetMedia of a missing video should fail")
+	}
+	if got := strings.Join(fake.requests, ","); got != "GetTweet 1003,GetTweet 1003,GetTweet 1003" {
+		t.Errorf("requests = %s", got)
+	}
+}
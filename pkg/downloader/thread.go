@@ -0,0 +1,236 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+// conversationManifest is saved next to the media of a thread or a
+// conversation. Tweets are in chronological order.
+type conversationManifest struct {
+	Mode           string          `json:"mode"`
+	TweetID        string          `json:"tweet_id"`
+	ConversationID string          `json:"conversation_id"`
+	Tweets         []manifestTweet `json:"tweets"`
+	Updated        time.Time       `json:"updated"`
+}
+
+type manifestTweet struct {
+	Position  int      `json:"position"`
+	ID        string   `json:"id"`
+	Username  string   `json:"username"`
+	InReplyTo string   `json:"in_reply_to,omitempty"`
+	Depth     int      `json:"depth"`
+	Timestamp int64    `json:"timestamp"`
+	Text      string   `json:"text"`
+	URL       string   `json:"url"`
+	Media     []string `json:"media,omitempty"`
+}
+
+// DownloadThread downloads the media of the thread of a tweet, the tweets
+// of its author replying to themselves, in Output/thread/CONVERSATION_ID,
+// with a thread.json manifest.
+func (d *Downloader) DownloadThread(ctx context.Context, id string) (*Result, error) {
+	return d.downloadConversation(ctx, id, "thread")
+}
+
+// DownloadConversation downloads the media of the whole reply tree of a
+// tweet in Output/conversation/CONVERSATION_ID, with a conversation.json
+// manifest.
+func (d *Downloader) DownloadConversation(ctx context.Context, id string) (*Result, error) {
+	return d.downloadConversation(ctx, id, "conversation")
+}
+
+func (d *Downloader) downloadConversation(ctx context.Context, id string, mode string) (*Result, error) {
+	result := d.start(ctx)
+	defer d.finish()
+
+	focal, err := d.fetchTweet(id)
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		return result, fmt.Errorf("failed to fetch tweet %s: %w", id, err)
+	}
+	conversationID := focal.ConversationID
+	if conversationID == "" {
+		conversationID = focal.ID
+	}
+
+	tweets, err := d.fetchConversation(conversationID, focal.Username, mode == "thread")
+	if err != nil {
+		return result, err
+	}
+	if mode == "thread" {
+		tweets = threadOf(tweets, focal, conversationID)
+	}
+	d.log.Infof("%d tweets in the %s of %s", len(tweets), mode, id)
+
+	output := d.opts.Output + "/" + mode + "/" + conversationID
+	os.MkdirAll(output, os.ModePerm)
+	if _, err := d.archive(output); err != nil {
+		return result, err
+	}
+
+	wg := sync.WaitGroup{}
+	for _, tweet := range tweets {
+		if ctx.Err() != nil {
+			break
+		}
+		d.checkAndPauseForBatch()
+		wg.Add(1)
+		go func(tweet *twitterscraper.Tweet) {
+			defer wg.Done()
+			d.videoSingle(tweet, output, false)
+			d.photoSingle(tweet, output, false)
+			d.gifSingle(tweet, output, false)
+			d.followQuote(tweet, output, 1)
+		}(tweet)
+		d.resultLock.Lock()
+		result.Tweets++
+		d.resultLock.Unlock()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	manifest := d.conversationManifest(mode, id, conversationID, tweets)
+	js, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	if err := os.WriteFile(output+"/"+mode+".json", js, 0644); err != nil {
+		return result, fmt.Errorf("failed to save the %s manifest: %w", mode, err)
+	}
+	return result, nil
+}
+
+// fetchConversation returns the tweets of a conversation, up to MaxTweets,
+// in chronological order. For a thread, only the continuations of the
+// author's replies are followed.
+func (d *Downloader) fetchConversation(conversationID string, author string, threadOnly bool) ([]*twitterscraper.Tweet, error) {
+	type page struct{ focal, cursor string }
+	pages := []page{{focal: conversationID}}
+	seenCursors := map[string]bool{}
+	seen := map[string]*twitterscraper.Tweet{}
+	var tweets []*twitterscraper.Tweet
+
+	for len(pages) > 0 && len(tweets) < d.opts.MaxTweets {
+		p := pages[0]
+		pages = pages[1:]
+		var found []*twitterscraper.Tweet
+		var cursors []*twitterscraper.ThreadCursor
+		err := d.retry429(func() (err error) {
+			if err := d.waitForRateLimit(); err != nil {
+				return err
+			}
+			found, cursors, err = d.scraper.GetTweetReplies(p.focal, p.cursor)
+			return err
+		})
+		if err != nil {
+			if d.ctx.Err() != nil {
+				return nil, d.ctx.Err()
+			}
+			if p.cursor == "" {
+				return nil, fmt.Errorf("failed to fetch conversation %s: %w", conversationID, err)
+			}
+			// The tweets of the other pages are still downloaded.
+			d.log.Warnf("Failed to fetch more replies of %s: %s", conversationID, err.Error())
+			continue
+		}
+
+		for _, tweet := range found {
+			if seen[tweet.ID] == nil && len(tweets) < d.opts.MaxTweets {
+				seen[tweet.ID] = tweet
+				tweets = append(tweets, tweet)
+			}
+		}
+		for _, c := range cursors {
+			switch c.CursorType {
+			case "Bottom", "ShowMore", "ShowMoreThreads", "ShowMoreThreadsPrompt":
+			default:
+				continue
+			}
+			if seenCursors[c.Cursor] {
+				continue
+			}
+			if threadOnly {
+				// Other replies are listed by Bottom cursors.
+				if parent := seen[c.ThreadID]; c.CursorType == "Bottom" || parent == nil || parent.Username != author {
+					continue
+				}
+			}
+			seenCursors[c.Cursor] = true
+			focal := c.FocalTweetID
+			if focal == "" {
+				focal = conversationID
+			}
+			pages = append(pages, page{focal: focal, cursor: c.Cursor})
+		}
+	}
+
+	sort.SliceStable(tweets, func(i, j int) bool { return compareIDs(tweets[i].ID, tweets[j].ID) < 0 })
+	return tweets, nil
+}
+
+// threadOf keeps the tweets of the author of focal replying to themselves,
+// starting from the root of the conversation, or from focal when someone
+// else started it.
+func threadOf(tweets []*twitterscraper.Tweet, focal *twitterscraper.Tweet, conversationID string) []*twitterscraper.Tweet {
+	var thread []*twitterscraper.Tweet
+	kept := map[string]bool{}
+	for _, tweet := range tweets {
+		if tweet.Username != focal.Username {
+			continue
+		}
+		if tweet.ID == conversationID || tweet.ID == focal.ID || kept[tweet.InReplyToStatusID] {
+			kept[tweet.ID] = true
+			thread = append(thread, tweet)
+		}
+	}
+	return thread
+}
+
+func (d *Downloader) conversationManifest(mode string, id string, conversationID string, tweets []*twitterscraper.Tweet) *conversationManifest {
+	media := map[string][]string{}
+	d.resultLock.Lock()
+	for _, m := range d.result.Downloaded {
+		media[m.TweetID] = append(media[m.TweetID], m.Path)
+	}
+	d.resultLock.Unlock()
+
+	manifest := &conversationManifest{Mode: mode, TweetID: id, ConversationID: conversationID, Updated: time.Now()}
+	depth := map[string]int{}
+	for i, tweet := range tweets {
+		if parent, ok := depth[tweet.InReplyToStatusID]; ok {
+			depth[tweet.ID] = parent + 1
+		} else {
+			depth[tweet.ID] = 0
+		}
+		url := tweet.PermanentURL
+		if url == "" {
+			url = "https://x.com/" + tweet.Username + "/status/" + tweet.ID
+		}
+		sort.Strings(media[tweet.ID])
+		manifest.Tweets = append(manifest.Tweets, manifestTweet{
+			Position:  i,
+			ID:        tweet.ID,
+			Username:  tweet.Username,
+			InReplyTo: tweet.InReplyToStatusID,
+			Depth:     depth[tweet.ID],
+			Timestamp: tweet.Timestamp,
+			Text:      tweet.Text,
+			URL:       url,
+			Media:     media[tweet.ID],
+		})
+	}
+	return manifest
+}
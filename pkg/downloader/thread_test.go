@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readManifest(t *testing.T, path string) *conversationManifest {
+	t.Helper()
+	js, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest conversationManifest
+	if err := json.Unmarshal(js, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return &manifest
+}
+
+func TestDownloadThread(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, DefaultOptions())
+	result, err := d.DownloadThread(context.Background(), "7001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(d.opts.Output, "thread", "7000")
+	if result.Tweets != 3 || len(result.Downloaded) != 3 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if got := files(t, output, "*.jpg"); len(got) != 2 {
+		t.Errorf("jpg = %v", got)
+	}
+
+	// The replies of other users aren't crawled.
+	want := "GetTweet 7001,GetTweetReplies 7000 ,GetTweetReplies 7000 more"
+	if got := strings.Join(fake.requests, ","); got != want {
+		t.Errorf("requests = %s", got)
+	}
+
+	manifest := readManifest(t, filepath.Join(output, "thread.json"))
+	var ids []string
+	for _, tweet := range manifest.Tweets {
+		ids = append(ids, tweet.ID)
+	}
+	if manifest.Mode != "thread" || manifest.TweetID != "7001" || manifest.ConversationID != "7000" || strings.Join(ids, ",") != "7000,7001,7004" {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	last := manifest.Tweets[2]
+	if last.Position != 2 || last.InReplyTo != "7001" || last.Depth != 2 || len(last.Media) != 1 || !strings.HasSuffix(last.Media[0], ".jpg") {
+		t.Errorf("last tweet = %+v", last)
+	}
+}
+
+func TestDownloadConversation(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, DefaultOptions())
+	result, err := d.DownloadConversation(context.Background(), "7002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tweets != 6 || len(result.Downloaded) != 5 {
+		t.Errorf("Tweets = %d, Downloaded = %d", result.Tweets, len(result.Downloaded))
+	}
+	if len(fake.requests) != 4 {
+		t.Errorf("requests = %v", fake.requests)
+	}
+
+	manifest := readManifest(t, filepath.Join(d.opts.Output, "conversation", "7000", "conversation.json"))
+	depths := map[string]int{}
+	for i, tweet := range manifest.Tweets {
+		if tweet.Position != i {
+			t.Errorf("position of %s = %d", tweet.ID, tweet.Position)
+		}
+		depths[tweet.ID] = tweet.Depth
+	}
+	want := map[string]int{"7000": 0, "7001": 1, "7002": 1, "7003": 2, "7004": 2, "7005": 1}
+	for id, depth := range want {
+		if got, ok := depths[id]; !ok || got != depth {
+			t.Errorf("depth of %s = %d, want %d", id, got, depth)
+		}
+	}
+}
+
+func TestDownloadConversationMaxTweets(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxTweets = 2
+	d, _, _ := newTestDownloader(t, opts)
+	result, err := d.DownloadConversation(context.Background(), "7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tweets != 2 {
+		t.Errorf("Tweets = %d, want 2", result.Tweets)
+	}
+}
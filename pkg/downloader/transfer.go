@@ -0,0 +1,29 @@
+package downloader
+
+// startWorkers starts the Concurrency workers running the media transfers of
+// a download, so that the number of connections to the media servers doesn't
+// depend on the number of tweets and media.
+func (d *Downloader) startWorkers() {
+	d.jobs = make(chan func())
+	for i := 0; i < d.opts.Concurrency; i++ {
+		d.workers.Add(1)
+		go func() {
+			defer d.workers.Done()
+			for job := range d.jobs {
+				job()
+			}
+		}()
+	}
+}
+
+// stopWorkers waits for the queued transfers and stops the workers.
+func (d *Downloader) stopWorkers() {
+	close(d.jobs)
+	d.workers.Wait()
+}
+
+// queue runs a media transfer on the next free worker. It blocks until a
+// worker takes it. A job must not queue other jobs.
+func (d *Downloader) queue(job func()) {
+	d.jobs <- job
+}
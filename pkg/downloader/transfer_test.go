@@ -0,0 +1,44 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 2} {
+		opts := allOptions()
+		opts.Concurrency = concurrency
+		opts.Retweets = true
+		d, _, media := newTestDownloader(t, opts)
+
+		var lock sync.Mutex
+		active, peak := 0, 0
+		media.hook = func(r *http.Request) {
+			lock.Lock()
+			active++
+			if active > peak {
+				peak = active
+			}
+			lock.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			lock.Lock()
+			active--
+			lock.Unlock()
+		}
+
+		result, err := d.DownloadUser(context.Background(), "fixture_user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Downloaded) != 5 {
+			t.Errorf("Downloaded %d media, want 5", len(result.Downloaded))
+		}
+		if peak > concurrency {
+			t.Errorf("%d concurrent transfers with Concurrency %d", peak, concurrency)
+		}
+	}
+}
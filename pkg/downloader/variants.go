@@ -0,0 +1,263 @@
+package downloader
+
+import (
+	"encoding/json"
+	"net/http"
+	URL "net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+const tweetResultURL = "https://x.com/i/api/graphql/xBtHv5-Xsk268T5ng_OGNg/TweetResultByRestId"
+
+// videoVariant is one of the encodings available for a video. HLS is the
+// playlist of the video, set on the chosen variant.
+type videoVariant struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Bitrate     int    `json:"bitrate"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	HLS         string `json:"hls,omitempty"`
+}
+
+// mediaDetail is the metadata of a media, as found in the tweet payload.
+// The scraper only keeps the url of the best variant, so the payload is
+// requested again when more is needed.
+type mediaDetail struct {
+	IDStr         string `json:"id_str"`
+	Type          string `json:"type"`
+	MediaURLHttps string `json:"media_url_https"`
+	OriginalInfo  struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"original_info"`
+	VideoInfo struct {
+		DurationMillis int `json:"duration_millis"`
+		Variants       []struct {
+			Bitrate     int    `json:"bitrate"`
+			ContentType string `json:"content_type"`
+			URL         string `json:"url"`
+		} `json:"variants"`
+	} `json:"video_info"`
+}
+
+type legacyMedia struct {
+	ExtendedEntities struct {
+		Media []mediaDetail `json:"media"`
+	} `json:"extended_entities"`
+}
+
+type tweetResultPayload struct {
+	Data struct {
+		TweetResult struct {
+			Result struct {
+				Legacy legacyMedia `json:"legacy"`
+				Tweet  struct {
+					Legacy legacyMedia `json:"legacy"`
+				} `json:"tweet"`
+			} `json:"result"`
+		} `json:"tweetResult"`
+	} `json:"data"`
+}
+
+var resolutionRegex = regexp.MustCompile(`/(\d+)x(\d+)/`)
+
+// fetchMediaDetails returns the media of a tweet with all their metadata.
+func (d *Downloader) fetchMediaDetails(id string) ([]mediaDetail, error) {
+	if media, ok := d.cachedMediaDetails(id); ok {
+		return media, nil
+	}
+
+	variables, _ := json.Marshal(map[string]interface{}{
+		"tweetId":                id,
+		"withCommunity":          false,
+		"includePromotedContent": false,
+		"withVoice":              false,
+	})
+	features, _ := json.Marshal(map[string]interface{}{
+		"creator_subscriptions_tweet_preview_api_enabled":                         true,
+		"c9s_tweet_anatomy_moderator_badge_enabled":                               true,
+		"tweetypie_unmention_optimization_enabled":                                true,
+		"responsive_web_edit_tweet_api_enabled":                                   true,
+		"graphql_is_translatable_rweb_tweet_is_translatable_enabled":              true,
+		"view_counts_everywhere_api_enabled":                                      true,
+		"longform_notetweets_consumption_enabled":                                 true,
+		"responsive_web_twitter_article_tweet_consumption_enabled":                true,
+		"tweet_awards_web_tipping_enabled":                                        false,
+		"freedom_of_speech_not_reach_fetch_enabled":                               true,
+		"standardized_nudges_misinfo":                                             true,
+		"tweet_with_visibility_results_prefer_gql_limited_actions_policy_enabled": true,
+		"rweb_video_timestamps_enabled":                                           true,
+		"longform_notetweets_rich_text_read_enabled":                              true,
+		"longform_notetweets_inline_media_enabled":                                true,
+		"responsive_web_graphql_exclude_directive_enabled":                        true,
+		"verified_phone_label_enabled":                                            false,
+		"responsive_web_graphql_skip_user_profile_image_extensions_enabled":       false,
+		"responsive_web_graphql_timeline_navigation_enabled":                      true,
+		"responsive_web_enhance_cards_enabled":                                    false,
+	})
+	query := URL.Values{}
+	query.Set("variables", string(variables))
+	query.Set("features", string(features))
+	req, err := http.NewRequestWithContext(d.ctx, "GET", tweetResultURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload tweetResultPayload
+	err = d.retry429(func() error {
+		if err := d.waitForRateLimit(); err != nil {
+			return err
+		}
+		return d.scraper.RequestAPI(req, &payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := payload.Data.TweetResult.Result
+	media := result.Legacy.ExtendedEntities.Media
+	if len(media) == 0 {
+		media = result.Tweet.Legacy.ExtendedEntities.Media
+	}
+
+	d.mediaDetailsLock.Lock()
+	d.mediaDetails[id] = media
+	d.mediaDetailsLock.Unlock()
+	return media, nil
+}
+
+func resolutionFromURL(url string) (int, int) {
+	m := resolutionRegex.FindStringSubmatch(url)
+	if m == nil {
+		return 0, 0
+	}
+	w, _ := strconv.Atoi(m[1])
+	h, _ := strconv.Atoi(m[2])
+	return w, h
+}
+
+// videoVariants lists the MP4 and HLS variants of a video.
+func videoVariants(media []mediaDetail, id string) []videoVariant {
+	var variants []videoVariant
+	for _, m := range media {
+		if m.IDStr != id {
+			continue
+		}
+		for _, v := range m.VideoInfo.Variants {
+			variant := videoVariant{
+				URL:         strings.Split(v.URL, "?")[0],
+				ContentType: v.ContentType,
+				Bitrate:     v.Bitrate,
+			}
+			variant.Width, variant.Height = resolutionFromURL(variant.URL)
+			variants = append(variants, variant)
+		}
+	}
+	return variants
+}
+
+// selectVariant picks the MP4 variant matching VideoQuality: best,
+// worst, <=720p (on the shortest side) or <=BITRATE. When no variant fits
+// the limit, the smallest one is used.
+func selectVariant(variants []videoVariant, quality string) *videoVariant {
+	var mp4 []videoVariant
+	for _, v := range variants {
+		if v.ContentType == "video/mp4" {
+			mp4 = append(mp4, v)
+		}
+	}
+	if len(mp4) == 0 {
+		return nil
+	}
+	sort.SliceStable(mp4, func(i, j int) bool { return mp4[i].Bitrate < mp4[j].Bitrate })
+
+	quality = strings.ToLower(quality)
+	switch quality {
+	case "", "best":
+		return &mp4[len(mp4)-1]
+	case "worst":
+		return &mp4[0]
+	}
+
+	limit := strings.TrimPrefix(quality, "<=")
+	var selected *videoVariant
+	if strings.HasSuffix(limit, "p") {
+		height, _ := strconv.Atoi(strings.TrimSuffix(limit, "p"))
+		for i, v := range mp4 {
+			side := v.Height
+			if v.Width < side {
+				side = v.Width
+			}
+			if side <= height {
+				selected = &mp4[i]
+			}
+		}
+	} else {
+		bitrate := parseBitrate(limit)
+		for i, v := range mp4 {
+			if v.Bitrate <= bitrate {
+				selected = &mp4[i]
+			}
+		}
+	}
+	if selected == nil {
+		selected = &mp4[0]
+	}
+	return selected
+}
+
+func parseBitrate(s string) int {
+	mult := 1
+	if strings.HasSuffix(s, "k") {
+		mult = 1000
+	} else if strings.HasSuffix(s, "m") {
+		mult = 1000000
+	}
+	n, _ := strconv.Atoi(strings.TrimRight(s, "km"))
+	return n * mult
+}
+
+// cachedMediaDetails returns the media of a tweet already known from a
+// timeline or an earlier request.
+func (d *Downloader) cachedMediaDetails(id string) ([]mediaDetail, bool) {
+	d.mediaDetailsLock.Lock()
+	defer d.mediaDetailsLock.Unlock()
+	media, ok := d.mediaDetails[id]
+	return media, ok
+}
+
+// chooseVariant returns the variant of the video to download. It falls back
+// to the url given by the scraper if the variants can't be listed. The
+// details are requested once per tweet, and not at all for the timelines
+// read from GraphQL.
+func (d *Downloader) chooseVariant(id string, video twitterscraper.Video) *videoVariant {
+	fallback := &videoVariant{URL: strings.Split(video.URL, "?")[0], ContentType: "video/mp4"}
+	fallback.Width, fallback.Height = resolutionFromURL(fallback.URL)
+	if video.HLSURL != "" {
+		fallback.HLS = strings.Split(video.HLSURL, "?")[0]
+	}
+
+	media, err := d.fetchMediaDetails(id)
+	if err != nil {
+		d.log.Warnf("Failed to list variants of video %s: %s", video.ID, err.Error())
+		return fallback
+	}
+	variants := videoVariants(media, video.ID)
+	selected := selectVariant(variants, d.opts.VideoQuality)
+	if selected == nil {
+		return fallback
+	}
+	selected.HLS = fallback.HLS
+	for _, v := range variants {
+		if v.ContentType == "application/x-mpegURL" {
+			selected.HLS = v.URL
+		}
+	}
+	d.log.Infof("Selected %dx%d variant at %d bps for video %s (%d variants)", selected.Width, selected.Height, selected.Bitrate, video.ID, len(variants))
+	return selected
+}
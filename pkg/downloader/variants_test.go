@@ -0,0 +1,68 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+
+	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+)
+
+func TestSelectVariant(t *testing.T) {
+	variants := []videoVariant{
+		{URL: "hls.m3u8", ContentType: "application/x-mpegURL"},
+		{URL: "720.mp4", ContentType: "video/mp4", Bitrate: 2176000, Width: 720, Height: 1280},
+		{URL: "320.mp4", ContentType: "video/mp4", Bitrate: 632000, Width: 320, Height: 568},
+		{URL: "480.mp4", ContentType: "video/mp4", Bitrate: 950000, Width: 480, Height: 852},
+	}
+	for quality, want := range map[string]string{
+		"best":    "720.mp4",
+		"":        "720.mp4",
+		"worst":   "320.mp4",
+		"<=480p":  "480.mp4",
+		"<=720P":  "720.mp4",
+		"<=100p":  "320.mp4",
+		"<=1m":    "480.mp4",
+		"<=700k":  "320.mp4",
+		"2176000": "720.mp4",
+	} {
+		if got := selectVariant(variants, quality); got == nil || got.URL != want {
+			t.Errorf("selectVariant(%q) = %v, want %s", quality, got, want)
+		}
+	}
+	if got := selectVariant(variants[:1], "best"); got != nil {
+		t.Errorf("selectVariant without mp4 = %v", got)
+	}
+}
+
+func TestChooseVariant(t *testing.T) {
+	opts := DefaultOptions()
+	opts.VideoQuality = "<=480p"
+	d, fake, media := newTestDownloader(t, opts)
+	tweet, _ := fake.GetTweet("1002")
+
+	variant := d.chooseVariant("1002", tweet.Videos[0])
+	if variant.URL != media.URL+"/ext_tw_video/2021/pu/vid/480x852/video_480.mp4" || variant.Bitrate != 950000 {
+		t.Errorf("variant = %+v", variant)
+	}
+
+	// Details are requested once per tweet.
+	d.chooseVariant("1002", tweet.Videos[0])
+	n := 0
+	for _, r := range fake.requests {
+		if r == "RequestAPI 1002" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d requests for the details of 1002", n)
+	}
+}
+
+func TestChooseVariantFallback(t *testing.T) {
+	d, _, _ := newTestDownloader(t, DefaultOptions())
+	video := twitterscraper.Video{ID: "1", URL: "https://video.twimg.com/ext_tw_video/1/pu/vid/640x360/a.mp4?tag=12"}
+	variant := d.chooseVariant("404", video)
+	if strings.Contains(variant.URL, "?") || variant.Width != 640 || variant.Height != 360 {
+		t.Errorf("variant = %+v", variant)
+	}
+}
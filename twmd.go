@@ -6,65 +6,45 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
-	"math/rand"
-	"net"
 	"net/http"
-	URL "net/url"
 	"os"
-	"reflect"
-	"regexp"
+	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
-	"unicode/utf8"
 
-	twitterscraper "github.com/jeffrey12cali/twitter-scraper"
+	"twmd/pkg/downloader"
+
 	"github.com/mmpx12/optionparser"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	usr       string
-	format    string
-	proxy     string
-	update    bool
-	onlyrtw   bool
-	onlymtw   bool
-	vidz      bool
-	imgs      bool
-	urlOnly   bool
-	authToken string
-	ct0Token  string
-	version   = "1.15.0"
-	scraper   *twitterscraper.Scraper
-	client    *http.Client
-	size      = "orig"
-	datefmt   = "2006-01-02"
+	usr          string
+	format       string
+	proxy        string
+	update       bool
+	onlyrtw      bool
+	onlymtw      bool
+	vidz         bool
+	imgs         bool
+	gifs         bool
+	urlOnly      bool
+	archiveFile  string
+	resume       bool
+	sinceID      string
+	authToken    string
+	ct0Token     string
+	version      = "1.15.0"
+	size         = "orig"
+	datefmt      = "2006-01-02"
+	videoQuality = "best"
+	gifFormat    = "mp4"
 
 	// Logger instance
 	logger = logrus.New()
-
-	// Rate limiting variables
-	requestCount     int
-	requestCountLock sync.Mutex
-	lastRequestTime  time.Time
-	lastMinuteReset  time.Time
-
-	// 429 error handling
-	consecutive429Count int
-	isCoolingDown       bool
-	coolingDownStart    time.Time
-
-	// Retry configuration
-	maxRetries    = 3
-	retryWaitBase = 10 * time.Second
-
-	// Batch control
-	tweetCount      int
-	batchPauseCount = 50
 )
 
 func init() {
@@ -76,773 +56,138 @@ func init() {
 	})
 	logger.SetOutput(os.Stdout)
 	logger.SetLevel(logrus.InfoLevel)
-
-	lastMinuteReset = time.Now()
-}
-
-func randomDuration(minSec, maxSec int) time.Duration {
-	sec := minSec + rand.Intn(maxSec-minSec+1)
-	return time.Duration(sec) * time.Second
-}
-
-func waitForRateLimit() {
-	requestCountLock.Lock()
-	defer requestCountLock.Unlock()
-
-	now := time.Now()
-
-	if now.Sub(lastMinuteReset) >= time.Minute {
-		requestCount = 0
-		lastMinuteReset = now
-	}
-
-	if requestCount >= 60 {
-		waitTime := time.Minute - now.Sub(lastMinuteReset)
-		logger.Warnf("Rate limit reached (60 requests/min), waiting %v", waitTime)
-		time.Sleep(waitTime)
-		requestCount = 0
-		lastMinuteReset = time.Now()
-	}
-
-	waitTime := time.Duration(1+rand.Intn(3)) * time.Second
-	if !lastRequestTime.IsZero() {
-		elapsed := now.Sub(lastRequestTime)
-		if elapsed < waitTime {
-			time.Sleep(waitTime - elapsed)
-		}
-	}
-
-	requestCount++
-	lastRequestTime = time.Now()
 }
 
-func handle429Error() bool {
-	requestCountLock.Lock()
-	consecutive429Count++
-	requestCountLock.Unlock()
-
-	if consecutive429Count == 1 {
-		waitTime := time.Duration(3+rand.Intn(3)) * time.Minute
-		logger.Warnf("429 Too Many Requests detected. Cooling down for %v (first offense)", waitTime)
-		isCoolingDown = true
-		coolingDownStart = time.Now()
-		time.Sleep(waitTime)
-		isCoolingDown = false
-		return true
-	} else if consecutive429Count >= 2 {
-		waitTime := time.Duration(10+rand.Intn(6)) * time.Minute
-		logger.Warnf("429 Too Many Requests detected again. Extended cooling down for %v (offense #%d)", waitTime, consecutive429Count)
-		isCoolingDown = true
-		coolingDownStart = time.Now()
-		time.Sleep(waitTime)
-		isCoolingDown = false
-		return true
-	}
-	return false
-}
-
-func reset429Count() {
-	requestCountLock.Lock()
-	consecutive429Count = 0
-	requestCountLock.Unlock()
-}
-
-func checkAndPauseForBatch() {
-	requestCountLock.Lock()
-	tweetCount++
-	currentCount := tweetCount
-	requestCountLock.Unlock()
-
-	if currentCount > 0 && currentCount%batchPauseCount == 0 {
-		waitTime := time.Duration(2+rand.Intn(2)) * time.Minute
-		logger.Infof("Processed %d tweets, pausing for %v to avoid rate limit", currentCount, waitTime)
-		time.Sleep(waitTime)
-	}
-}
-
-func getRetryWaitTime(retryCount int) time.Duration {
-	waitTime := retryWaitBase * time.Duration(1<<retryCount)
-	randomAdd := time.Duration(rand.Intn(10)) * time.Second
-	return waitTime + randomAdd
-}
-
-func generateNFOFile(tweet interface{}, videoUrl string, output string, dwn_type string) {
-	// Generate nfo filename (same as video but with .nfo extension)
-	segments := strings.Split(videoUrl, "/")
-	videoName := segments[len(segments)-1]
-	re := regexp.MustCompile(`name=`)
-	if re.MatchString(videoName) {
-		segments := strings.Split(videoName, "?")
-		videoName = segments[len(segments)-2]
-	}
-
-	// Get tweet content for filename
-	tweetContent := "没有推文"
-	pattern := `[/\\:*?"<>|]`
-	regex, _ := regexp.Compile(pattern)
-
-	// Extract tweet information
-	var title, description, author, date string
-	var tweetID string
-
-	// Log tweet processing
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		tweetID = t.ID
-	case *twitterscraper.Tweet:
-		tweetID = t.ID
-	}
-	logger.Infof("Processing tweet: %s", tweetID)
-
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-			description = t.Text
-		} else {
-			description = "没有推文"
-		}
-		title = t.Name + "的推文"
-		author = t.Username
-		date = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-		tweetID = t.ID
-	case *twitterscraper.Tweet:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-			description = t.Text
-		} else {
-			description = "没有推文"
-		}
-		title = t.Name + "的推文"
-		author = t.Username
-		date = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-		tweetID = t.ID
-	}
-
-	// Get tweet date for filename prefix
-	var tweetDate string
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	case *twitterscraper.Tweet:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	}
-
-	// Create nfo filename
-	nameWithoutExt := strings.TrimSuffix(videoName, "."+strings.Split(videoName, ".")[len(strings.Split(videoName, "."))-1])
-	nfoName := tweetDate + "_" + nameWithoutExt + "_" + tweetContent + ".nfo"
-
-	// Create nfo file path
-	var nfoPath string
-	if dwn_type == "user" {
-		if _, err := os.Stat(output + "/video"); os.IsNotExist(err) {
-			os.MkdirAll(output+"/video", os.ModePerm)
+func parseRetryStatus(codes string) ([]int, error) {
+	var status []int
+	for _, c := range strings.Split(codes, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
 		}
-		nfoPath = output + "/video/" + nfoName
-	} else {
-		if _, err := os.Stat(output); os.IsNotExist(err) {
-			os.MkdirAll(output, os.ModePerm)
+		code, err := strconv.Atoi(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q", c)
 		}
-		nfoPath = output + "/" + nfoName
-	}
-
-	// Generate nfo content (Jellyfin compatible XML)
-	nfoContent := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<movie>
-  <title>%s</title>
-  <originaltitle>%s</originaltitle>
-  <plot>%s</plot>
-  <outline>%s</outline>
-  <year>%s</year>
-  <premiered>%s</premiered>
-  <aired>%s</aired>
-  <studio>Twitter</studio>
-  <director>%s</director>
-  <credits>%s</credits>
-  <actor>
-    <name>%s</name>
-    <role>作者</role>
-  </actor>
-  <tag>Twitter</tag>
-  <tag>视频</tag>
-  <uniqueid type="twitter" default="true">%s</uniqueid>
-</movie>`,
-		title, title, description, description, date[:4], date, date, author, author, author, tweetID)
-
-	// Write nfo file
-	err := os.WriteFile(nfoPath, []byte(nfoContent), 0644)
-	if err == nil {
-		logger.Infof("Generated NFO file: %s", nfoName)
-		logger.Infof("NFO file path: %s", nfoPath)
-	} else {
-		logger.Errorf("Failed to generate NFO file: %s", err.Error())
+		status = append(status, code)
 	}
+	return status, nil
 }
 
-func generateASSFile(tweet interface{}, videoUrl string, output string, dwn_type string) {
-	// Generate ass filename (same as video but with .ass extension)
-	segments := strings.Split(videoUrl, "/")
-	videoName := segments[len(segments)-1]
-	re := regexp.MustCompile(`name=`)
-	if re.MatchString(videoName) {
-		segments := strings.Split(videoName, "?")
-		videoName = segments[len(segments)-2]
-	}
-
-	// Get tweet content for subtitle
-	tweetContent := "没有推文"
-	pattern := `[/\\:*?\"<>|]`
-	regex, _ := regexp.Compile(pattern)
-
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-		}
-	case *twitterscraper.Tweet:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
+// parseDate parses a date in local time, as 2006-01-02, 2006-01-02 15:04:05
+// or RFC 3339. A day given to --until is included.
+func parseDate(flag string, value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if flag == "--until" {
+			day = day.AddDate(0, 0, 1)
 		}
+		return day, nil
 	}
-
-	// Get tweet date for filename prefix
-	var tweetDate string
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	case *twitterscraper.Tweet:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	}
-
-	// Create ass filename
-	nameWithoutExt := strings.TrimSuffix(videoName, "."+strings.Split(videoName, ".")[len(strings.Split(videoName, "."))-1])
-	assName := tweetDate + "_" + nameWithoutExt + "_" + tweetContent + ".ass"
-
-	// Create ass file path
-	var assPath string
-	if dwn_type == "user" {
-		if _, err := os.Stat(output + "/video"); os.IsNotExist(err) {
-			os.MkdirAll(output+"/video", os.ModePerm)
-		}
-		assPath = output + "/video/" + assName
-	} else {
-		if _, err := os.Stat(output); os.IsNotExist(err) {
-			os.MkdirAll(output, os.ModePerm)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
 		}
-		assPath = output + "/" + assName
-	}
-
-	// Generate ass content
-	assContent := fmt.Sprintf(`[Script Info]
-; Script generated by twmd
-Title: Twitter Video Subtitle
-Original Script: twmd
-ScriptType: v4.00+
-Collisions: Normal
-PlayResX: 1080
-PlayResY: 1920
-WrapStyle: 3
-ScaledBorderAndShadow: yes
-
-[V4+ Styles]
-Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
-Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000099,0,0,0,0,100,100,0,0,1,2,2,2,40,40,80,1
-
-[Events]
-Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
-Dialogue: 0,0:00:00.00,99:59:59.99,Default,,40,40,80,,%s`,
-		tweetContent)
-
-	// Write ass file
-	err := os.WriteFile(assPath, []byte(assContent), 0644)
-	if err == nil {
-		logger.Infof("Generated ASS file: %s", assName)
-		logger.Infof("ASS file path: %s", assPath)
-	} else {
-		logger.Errorf("Failed to generate ASS file: %s", err.Error())
 	}
+	return time.Time{}, fmt.Errorf("invalid %s %q, expected a date as 2006-01-02 or 2006-01-02 15:04:05", flag, value)
 }
 
-func saveTweetJSON(tweet interface{}, videoUrl string, output string, dwn_type string) {
-	// Generate JSON filename (same as video but with .json extension)
-	segments := strings.Split(videoUrl, "/")
-	videoName := segments[len(segments)-1]
-	re := regexp.MustCompile(`name=`)
-	if re.MatchString(videoName) {
-		segments := strings.Split(videoName, "?")
-		videoName = segments[len(segments)-2]
+// parseSeconds parses a number of seconds or a duration such as 1m30s.
+func parseSeconds(flag string, value string) (time.Duration, error) {
+	if n, err := strconv.ParseFloat(value, 64); err == nil && n >= 0 {
+		return time.Duration(n * float64(time.Second)), nil
 	}
-
-	// Get tweet content for filename
-	tweetContent := "没有推文"
-	pattern := `[/\\:*?"<>|]`
-	regex, _ := regexp.Compile(pattern)
-
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-		}
-	case *twitterscraper.Tweet:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-		}
-	}
-
-	// Get tweet date for filename prefix
-	var tweetDate string
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	case *twitterscraper.Tweet:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	}
-
-	// Create JSON filename
-	nameWithoutExt := strings.TrimSuffix(videoName, "."+strings.Split(videoName, ".")[len(strings.Split(videoName, "."))-1])
-	jsonName := tweetDate + "_" + nameWithoutExt + "_" + tweetContent + ".json"
-
-	// Create JSON file path
-	var jsonPath string
-	if dwn_type == "user" {
-		if _, err := os.Stat(output + "/video"); os.IsNotExist(err) {
-			os.MkdirAll(output+"/video", os.ModePerm)
-		}
-		jsonPath = output + "/video/" + jsonName
-	} else {
-		if _, err := os.Stat(output); os.IsNotExist(err) {
-			os.MkdirAll(output, os.ModePerm)
-		}
-		jsonPath = output + "/" + jsonName
-	}
-
-	// Marshal tweet to JSON
-	tweetJSON, err := json.MarshalIndent(tweet, "", "  ")
-	if err != nil {
-		logger.Errorf("Failed to marshal tweet to JSON: %s", err.Error())
-		return
-	}
-
-	// Write JSON file
-	err = os.WriteFile(jsonPath, tweetJSON, 0644)
-	if err == nil {
-		logger.Infof("Saved tweet JSON: %s", jsonName)
-		logger.Infof("JSON file path: %s", jsonPath)
-	} else {
-		logger.Errorf("Failed to save tweet JSON: %s", err.Error())
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d, nil
 	}
+	return 0, fmt.Errorf("invalid %s %q, expected seconds or a duration as 1m30s", flag, value)
 }
 
-func downloadThumbnail(wg *sync.WaitGroup, tweet interface{}, video interface{}, videoUrl string, output string, dwn_type string) {
-	defer wg.Done()
-
-	// Log thumbnail download start
-	logger.Infof("Starting thumbnail download")
-	logger.Infof("Video URL: %s", videoUrl)
-	logger.Infof("Output directory: %s", output)
-
-	// Extract thumbnail URL from video object
-	var thumbnailUrl string
-
-	// First, try to get thumbnail URL from video object using reflection
-	v := reflect.ValueOf(video)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-
-	// Try to get Preview field from video object
-	previewField := v.FieldByName("Preview")
-	if previewField.IsValid() {
-		// Check if Preview is a struct with URL field
-		if previewField.Kind() == reflect.Struct {
-			urlField := previewField.FieldByName("URL")
-			if urlField.IsValid() && urlField.Kind() == reflect.String {
-				thumbnailUrl = urlField.String()
-			}
-		} else if previewField.Kind() == reflect.String {
-			// Check if Preview is directly a string URL
-			thumbnailUrl = previewField.String()
-		}
-	}
-
-	// If no Preview field found, try PreviewURL field
-	if thumbnailUrl == "" {
-		previewURLField := v.FieldByName("PreviewURL")
-		if previewURLField.IsValid() && previewURLField.Kind() == reflect.String {
-			thumbnailUrl = previewURLField.String()
+// parseFileSize parses a size in bytes, with an optional K, M or G suffix.
+func parseFileSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(value), "B"))
+	unit := 1.0
+	if s != "" {
+		if u, ok := map[byte]float64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30}[s[len(s)-1]]; ok {
+			unit = u
+			s = s[:len(s)-1]
 		}
 	}
-
-	if thumbnailUrl == "" {
-		logger.Errorf("No Preview field found in video object for: %s", videoUrl)
-		return
-	}
-
-	// Ensure thumbnail URL is valid
-	if !strings.HasPrefix(thumbnailUrl, "http") {
-		logger.Errorf("Invalid thumbnail URL: %s", thumbnailUrl)
-		return
-	}
-
-	logger.Infof("Trying to download thumbnail from: %s", thumbnailUrl)
-
-	// Generate thumbnail filename (same as video but with .jpg extension)
-	segments := strings.Split(videoUrl, "/")
-	videoName := segments[len(segments)-1]
-	re := regexp.MustCompile(`name=`)
-	if re.MatchString(videoName) {
-		segments := strings.Split(videoName, "?")
-		videoName = segments[len(segments)-2]
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid --max-filesize %q, expected a size as 500K, 50M or 1.5G", value)
 	}
+	return int64(n * unit), nil
+}
 
-	// Get tweet content for filename
-	tweetContent := "没有推文"
-	pattern := `[/\\:*?"<>|]`
-	regex, _ := regexp.Compile(pattern)
-
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-		}
-	case *twitterscraper.Tweet:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-		}
-	}
-
-	// Get tweet date for filename prefix
-	var tweetDate string
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	case *twitterscraper.Tweet:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	}
-
-	// Create thumbnail filename
-	nameWithoutExt := strings.TrimSuffix(videoName, "."+strings.Split(videoName, ".")[len(strings.Split(videoName, "."))-1])
-	thumbnailName := tweetDate + "_" + nameWithoutExt + "_" + tweetContent + ".jpg"
-
-	// Download thumbnail
-	req, err := http.NewRequest("GET", thumbnailUrl, nil)
-	req.Header.Add("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
-	resp, err := client.Do(req)
-
-	if resp != nil {
-		defer resp.Body.Close()
-	}
-	if err != nil {
-		logger.Errorf("Error downloading thumbnail: %s", err.Error())
-		return
-	}
-	if resp.StatusCode != 200 {
-		logger.Errorf("Error downloading thumbnail, status code: %d", resp.StatusCode)
-		return
-	}
-
-	logger.Infof("Thumbnail download started")
-
-	// Save thumbnail to video directory
-	var f *os.File
-	if dwn_type == "user" {
-		if _, err := os.Stat(output + "/video"); os.IsNotExist(err) {
-			os.MkdirAll(output+"/video", os.ModePerm)
-		}
-		f, _ = os.Create(output + "/video/" + thumbnailName)
-	} else {
-		if _, err := os.Stat(output); os.IsNotExist(err) {
-			os.MkdirAll(output, os.ModePerm)
-		}
-		f, _ = os.Create(output + "/" + thumbnailName)
+// parseUser accepts a username or a profile link for flag.
+func parseUser(flag string, value string) (string, error) {
+	target, err := downloader.ParseTarget(value)
+	if err == nil && target.Kind == downloader.TargetUser {
+		return target.Username, nil
 	}
-	if f != nil {
-		defer f.Close()
-		_, err = io.Copy(f, resp.Body)
-		if err != nil {
-			logger.Errorf("Failed to save thumbnail: %s", err.Error())
-			return
-		}
-		logger.Infof("Downloaded thumbnail: %s", thumbnailName)
+	// Usernames may be numbers.
+	if name := strings.TrimPrefix(strings.TrimSpace(value), "@"); name != "" && !strings.ContainsAny(name, "/. ") {
+		return name, nil
 	}
+	return "", fmt.Errorf("invalid %s %q, expected a username or https://x.com/USERNAME", flag, value)
 }
 
-func download(wg *sync.WaitGroup, tweet interface{}, url string, filetype string, output string, dwn_type string) {
-	defer wg.Done()
-	segments := strings.Split(url, "/")
-	name := segments[len(segments)-1]
-	re := regexp.MustCompile(`name=`)
-	if re.MatchString(name) {
-		segments := strings.Split(name, "?")
-		name = segments[len(segments)-2]
+// parseListID accepts a list id or a list link.
+func parseListID(list string) (string, error) {
+	target, err := downloader.ParseTarget(list)
+	if err == nil && target.Kind == downloader.TargetList {
+		return target.ID, nil
 	}
-
-	// Log download start
-	logger.Infof("Starting download: %s", name)
-	logger.Infof("URL: %s", url)
-	logger.Infof("File type: %s", filetype)
-	logger.Infof("Output directory: %s", output)
-	// Get tweet content
-	tweetContent := "没有推文"
-	pattern := `[/\\:*?"<>|]`
-	regex, _ := regexp.Compile(pattern)
-
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-		}
-	case *twitterscraper.Tweet:
-		if t.Text != "" {
-			tweetContent = sanitizeText(t.Text, regex, 20)
-		}
+	// A bare number is a list id here.
+	if err == nil && target.Kind == downloader.TargetTweet && strings.TrimSpace(list) == target.ID {
+		return target.ID, nil
 	}
+	return "", fmt.Errorf("invalid list %q, expected an id or https://x.com/i/lists/ID", list)
+}
 
-	// Get tweet date for filename prefix
-	var tweetDate string
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	case *twitterscraper.Tweet:
-		tweetDate = time.Unix(t.Timestamp, 0).Format("2006-01-02")
-	}
-
-	// Add tweet content to filename
-	nameWithoutExt := strings.TrimSuffix(name, "."+strings.Split(name, ".")[len(strings.Split(name, "."))-1])
-	ext := "." + strings.Split(name, ".")[len(strings.Split(name, "."))-1]
-	name = tweetDate + "_" + nameWithoutExt + "_" + tweetContent + ext
-
-	if format != "" {
-		name = getFormat(tweet) + "_" + name
-	}
-	if urlOnly {
-		logger.Info(url)
-		time.Sleep(2 * time.Millisecond)
+func printSummary(result *downloader.Result) {
+	if result == nil {
 		return
 	}
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
-	resp, err := client.Do(req)
-
-	if resp != nil {
-		defer resp.Body.Close()
-	}
-	if err != nil {
-		logger.Errorf("Download failed: %s", err.Error())
+	logger.Infof("%d tweet(s) processed, %d file(s) downloaded, %d failed", result.Tweets, len(result.Downloaded), len(result.Failed))
+	if len(result.Failed) == 0 {
 		return
 	}
-
-	if resp.StatusCode != 200 {
-		logger.Errorf("Download failed with status code: %d", resp.StatusCode)
-		return
+	logger.Errorf("%d download(s) failed:", len(result.Failed))
+	for _, f := range result.Failed {
+		logger.Errorf("  tweet %s: %s -> %s: %s", f.TweetID, f.URL, f.Path, f.Err.Error())
 	}
-
-	logger.Infof("Download started: %s", name)
-
-	var f *os.File
-	if dwn_type == "user" {
-		if update {
-			// Check both full filename and original filename
-			var filePath string
-			if filetype == "rtimg" {
-				filePath = output + "/img/RE-" + name
-			} else if filetype == "rtvideo" {
-				filePath = output + "/video/RE-" + name
-			} else {
-				filePath = output + "/" + filetype + "/" + name
-			}
-
-			// Check full filename
-			if _, err := os.Stat(filePath); !errors.Is(err, os.ErrNotExist) {
-				logger.Infof("File already exists: %s", name)
-				return
-			}
-
-			// Check original filename (without date and tweet content)
-			originalName := strings.Split(name, "_")[1] + "." + strings.Split(name, ".")[len(strings.Split(name, "."))-1]
-			var originalFilePath string
-			if filetype == "rtimg" {
-				originalFilePath = output + "/img/RE-" + originalName
-			} else if filetype == "rtvideo" {
-				originalFilePath = output + "/video/RE-" + originalName
-			} else {
-				originalFilePath = output + "/" + filetype + "/" + originalName
-			}
-			if _, err := os.Stat(originalFilePath); !errors.Is(err, os.ErrNotExist) {
-				logger.Infof("Original file already exists: %s", originalName)
-				return
-			}
-		}
-		if filetype == "rtimg" {
-			f, _ = os.Create(output + "/img/RE-" + name)
-		} else if filetype == "rtvideo" {
-			f, _ = os.Create(output + "/video/RE-" + name)
-		} else {
-			f, _ = os.Create(output + "/" + filetype + "/" + name)
-		}
-	} else {
-		if update {
-			// Check both full filename and original filename
-			filePath := output + "/" + name
-
-			// Check full filename
-			if _, err := os.Stat(filePath); !errors.Is(err, os.ErrNotExist) {
-				logger.Infof("File already exists: %s", name)
-				return
-			}
-
-			// Check original filename (without date and tweet content)
-			originalName := strings.Split(name, "_")[1] + "." + strings.Split(name, ".")[len(strings.Split(name, "."))-1]
-			originalFilePath := output + "/" + originalName
-			if _, err := os.Stat(originalFilePath); !errors.Is(err, os.ErrNotExist) {
-				logger.Infof("Original file already exists: %s", originalName)
-				return
-			}
-		}
-		f, _ = os.Create(output + "/" + name)
-	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
-	if err != nil {
-		logger.Errorf("Failed to save file: %s", err.Error())
-		return
-	}
-	logger.Infof("Download completed: %s", name)
 }
 
-func videoUser(wait *sync.WaitGroup, tweet *twitterscraper.TweetResult, output string, rt bool) {
-	defer wait.Done()
-	wg := sync.WaitGroup{}
-	if len(tweet.Videos) > 0 {
-		logger.Infof("Processing %d videos for tweet: %s", len(tweet.Videos), tweet.ID)
-		for _, i := range tweet.Videos {
-			url := strings.Split(i.URL, "?")[0]
-			logger.Infof("Processing video: %s", url)
-			if tweet.IsRetweet {
-				if rt || onlyrtw {
-					wg.Add(1)
-					go download(&wg, tweet, url, "video", output, "user")
-					// Download video thumbnail
-					wg.Add(1)
-					go downloadThumbnail(&wg, tweet, i, url, output, "user")
-					// Generate NFO file
-					generateNFOFile(tweet, url, output, "user")
-					// Generate ASS subtitle file
-					generateASSFile(tweet, url, output, "user")
-					// Save tweet JSON
-					saveTweetJSON(tweet, url, output, "user")
-					continue
-				} else {
-					continue
-				}
-			} else if onlyrtw {
-				continue
-			}
-			wg.Add(1)
-			go download(&wg, tweet, url, "video", output, "user")
-			// Download video thumbnail
-			wg.Add(1)
-			go downloadThumbnail(&wg, tweet, i, url, output, "user")
-			// Generate NFO file
-			generateNFOFile(tweet, url, output, "user")
-			// Generate ASS subtitle file
-			generateASSFile(tweet, url, output, "user")
-			// Save tweet JSON
-			saveTweetJSON(tweet, url, output, "user")
+func printBatchSummary(items []downloader.BatchItem) {
+	failed := 0
+	for _, item := range items {
+		if item.Err != nil {
+			failed++
 		}
-		wg.Wait()
 	}
-}
-
-func photoUser(wait *sync.WaitGroup, tweet *twitterscraper.TweetResult, output string, rt bool) {
-	defer wait.Done()
-	wg := sync.WaitGroup{}
-	if len(tweet.Photos) > 0 || tweet.IsRetweet {
-		if tweet.IsRetweet && (rt || onlyrtw) {
-			singleTweet(output, tweet.ID)
-		}
-		for _, i := range tweet.Photos {
-			if onlyrtw || tweet.IsRetweet {
-				continue
-			}
-			var url string
-			if !strings.Contains(i.URL, "video_thumb/") {
-				if size == "orig" || size == "small" {
-					url = i.URL + "?name=" + size
-				} else {
-					url = i.URL
-				}
-				wg.Add(1)
-				go download(&wg, tweet, url, "img", output, "user")
-			}
-		}
-		wg.Wait()
-	}
-}
-
-func videoSingle(tweet *twitterscraper.Tweet, output string) {
-	if tweet == nil {
-		return
-	}
-	if len(tweet.Videos) > 0 {
-		wg := sync.WaitGroup{}
-		for _, i := range tweet.Videos {
-			url := strings.Split(i.URL, "?")[0]
-			if usr != "" {
-				wg.Add(1)
-				go download(&wg, tweet, url, "rtvideo", output, "user")
-				// Download video thumbnail
-				wg.Add(1)
-				go downloadThumbnail(&wg, tweet, i, url, output, "user")
-				// Generate NFO file
-				generateNFOFile(tweet, url, output, "user")
-				// Generate ASS subtitle file
-				generateASSFile(tweet, url, output, "user")
-				// Save tweet JSON
-				saveTweetJSON(tweet, url, output, "user")
-			} else {
-				wg.Add(1)
-				go download(&wg, tweet, url, "tweet", output, "tweet")
-				// Download video thumbnail
-				wg.Add(1)
-				go downloadThumbnail(&wg, tweet, i, url, output, "tweet")
-				// Generate NFO file
-				generateNFOFile(tweet, url, output, "tweet")
-				// Generate ASS subtitle file
-				generateASSFile(tweet, url, output, "tweet")
-				// Save tweet JSON
-				saveTweetJSON(tweet, url, output, "tweet")
-			}
+	logger.Infof("%d line(s) processed, %d succeeded, %d failed", len(items), len(items)-failed, failed)
+	for _, item := range items {
+		if item.Err != nil {
+			logger.Errorf("  line %d %s: %s", item.Line, item.Input, item.Err.Error())
+		} else {
+			logger.Infof("  line %d %s: %d tweet(s), %d file(s) downloaded, %d failed", item.Line, item.Input, item.Tweets, item.Downloaded, item.Failed)
 		}
-		wg.Wait()
 	}
 }
 
-func photoSingle(tweet *twitterscraper.Tweet, output string) {
-	if tweet == nil {
-		return
+// readBatch reads the lines of a batch file, or of stdin for "-".
+func readBatch(file string) ([]downloader.BatchLine, error) {
+	if file == "-" {
+		return downloader.ReadBatch(os.Stdin)
 	}
-	if len(tweet.Photos) > 0 {
-		wg := sync.WaitGroup{}
-		for _, i := range tweet.Photos {
-			var url string
-			if !strings.Contains(i.URL, "video_thumb/") {
-				if size == "orig" || size == "small" {
-					url = i.URL + "?name=" + size
-				} else {
-					url = i.URL
-				}
-				if usr != "" {
-					wg.Add(1)
-					go download(&wg, tweet, url, "rtimg", output, "user")
-				} else {
-					wg.Add(1)
-					go download(&wg, tweet, url, "tweet", output, "tweet")
-				}
-			}
-		}
-		wg.Wait()
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+	return downloader.ReadBatch(f)
 }
 
 func processCookieString(cookieStr string) []*http.Cookie {
@@ -875,7 +220,7 @@ func processCookieString(cookieStr string) []*http.Cookie {
 	return cookies
 }
 
-func Login(useCookies bool) {
+func Login(dl *downloader.Downloader, useCookies bool) {
 	logger.Infof("Login function called, useCookies: %v", useCookies)
 	logger.Infof("authToken provided: %s", authToken)
 	logger.Infof("ct0Token provided: %s", ct0Token)
@@ -888,7 +233,7 @@ func Login(useCookies bool) {
 			cookieStr = strings.TrimSpace(cookieStr)
 
 			cookies := processCookieString(cookieStr)
-			scraper.SetCookies(cookies)
+			dl.SetCookies(cookies)
 
 			js, _ := json.MarshalIndent(cookies, "", "  ")
 			f, _ := os.OpenFile("twmd_cookies.json", os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
@@ -898,13 +243,13 @@ func Login(useCookies bool) {
 			f, _ := os.Open("twmd_cookies.json")
 			var cookies []*http.Cookie
 			json.NewDecoder(f).Decode(&cookies)
-			scraper.SetCookies(cookies)
-			logger.Info(scraper.IsLoggedIn())
+			dl.SetCookies(cookies)
+			logger.Info(dl.IsLoggedIn())
 		}
 	} else {
 		if authToken != "" && ct0Token != "" {
 			logger.Info("Setting auth token from parameters")
-			scraper.SetAuthToken(twitterscraper.AuthToken{Token: authToken, CSRFToken: ct0Token})
+			dl.SetAuthToken(authToken, ct0Token)
 		} else if _, err := os.Stat("twmd_cookies.json"); errors.Is(err, fs.ErrNotExist) {
 			logger.Error("auth_token and ct0 cookies are required. Please provide them via --auth-token and --ct0 parameters.")
 			os.Exit(1)
@@ -912,12 +257,12 @@ func Login(useCookies bool) {
 			f, _ := os.Open("twmd_cookies.json")
 			var cookies []*http.Cookie
 			json.NewDecoder(f).Decode(&cookies)
-			scraper.SetCookies(cookies)
+			dl.SetCookies(cookies)
 		}
 	}
 
 	logger.Info("Checking login status...")
-	isLoggedIn := scraper.IsLoggedIn()
+	isLoggedIn := dl.IsLoggedIn()
 	logger.Infof("Login status: %v", isLoggedIn)
 
 	if !isLoggedIn {
@@ -931,7 +276,7 @@ func Login(useCookies bool) {
 	} else {
 		logger.Info("Logged in successfully.")
 		// Save cookies to file for future use
-		cookies := scraper.GetCookies()
+		cookies := dl.Cookies()
 		js, _ := json.MarshalIndent(cookies, "", "  ")
 		f, _ := os.OpenFile("twmd_cookies.json", os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
 		defer f.Close()
@@ -940,163 +285,66 @@ func Login(useCookies bool) {
 	}
 }
 
-func singleTweet(output string, id string) {
-	waitForRateLimit()
-
-	var lastErr error
-	for retry := 0; retry < maxRetries; retry++ {
-		tweet, err := scraper.GetTweet(id)
-		if err != nil {
-			lastErr = err
-			if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "Too Many Requests") {
-				if !handle429Error() {
-					break
-				}
-				continue
-			}
-			logger.Errorf("Error fetching tweet: %s", err.Error())
-			if retry < maxRetries-1 {
-				waitTime := getRetryWaitTime(retry)
-				logger.Infof("Retrying in %v (attempt %d/%d)", waitTime, retry+1, maxRetries)
-				time.Sleep(waitTime)
-				continue
-			}
-			os.Exit(1)
-		}
-		if tweet == nil {
-			logger.Error("Error retrieve tweet")
-			return
-		}
-		reset429Count()
-		checkAndPauseForBatch()
-		if usr != "" {
-			if vidz {
-				videoSingle(tweet, output)
-			}
-			if imgs {
-				photoSingle(tweet, output)
-			}
-		} else {
-			videoSingle(tweet, output)
-			photoSingle(tweet, output)
-		}
-		return
-	}
-	if lastErr != nil {
-		logger.Errorf("Failed to fetch tweet after %d retries: %s", maxRetries, lastErr.Error())
-	}
-}
-
-func getFormat(tweet interface{}) string {
-	var formatNew string
-	var tweetResult *twitterscraper.TweetResult
-	var tweetObj *twitterscraper.Tweet
-
-	switch t := tweet.(type) {
-	case *twitterscraper.TweetResult:
-		tweetResult = t
-	case *twitterscraper.Tweet:
-		tweetObj = t
-	default:
-		logger.Error("Invalid tweet type")
-		return ""
-	}
-
-	pattern := `[/\\:*?"<>|]`
-	regex, err := regexp.Compile(pattern)
-	if err != nil {
-		logger.Error("Error compiling regular expression:", err)
-		return ""
-	}
-
-	replacer := map[string]string{}
-
-	if tweetResult != nil {
-		replacer["{DATE}"] = time.Unix(tweetResult.Timestamp, 0).Format(datefmt)
-		replacer["{NAME}"] = tweetResult.Name
-		replacer["{USERNAME}"] = tweetResult.Username
-		replacer["{TITLE}"] = sanitizeText(tweetResult.Text, regex, 255)
-		replacer["{ID}"] = tweetResult.ID
-	} else if tweetObj != nil {
-		replacer["{DATE}"] = time.Unix(tweetObj.Timestamp, 0).Format(datefmt)
-		replacer["{NAME}"] = tweetObj.Name
-		replacer["{USERNAME}"] = tweetObj.Username
-		replacer["{TITLE}"] = sanitizeText(tweetObj.Text, regex, 255)
-		replacer["{ID}"] = tweetObj.ID
-	}
-
-	formatNew = format
-
-	for key, val := range replacer {
-		formatNew = strings.ReplaceAll(formatNew, key, val)
-	}
-
-	return formatNew
-}
-
-func sanitizeText(text string, regex *regexp.Regexp, maxLen int) string {
-	// 1. 剔除URL
-	urlRegex := regexp.MustCompile(`https?://[\w\-._~:/?#[\]@!$&'()*+,;=.]+`)
-	text = urlRegex.ReplaceAllString(text, "")
-
-	// 2. 剔除换行符和制表符
-	text = strings.ReplaceAll(text, "\n", " ")
-	text = strings.ReplaceAll(text, "\r", " ")
-	text = strings.ReplaceAll(text, "\t", " ")
-
-	// 3. 剔除连续空格
-	spaceRegex := regexp.MustCompile(`\s+`)
-	text = spaceRegex.ReplaceAllString(text, " ")
-
-	// 4. 剔除emoji
-	emojiRegex := regexp.MustCompile(`[\x{1F600}-\x{1F64F}\x{1F300}-\x{1F5FF}\x{1F680}-\x{1F6FF}\x{1F1E0}-\x{1F1FF}\x{2600}-\x{26FF}\x{2700}-\x{27BF}]`)
-	text = emojiRegex.ReplaceAllString(text, "")
-
-	// 5. 清理剩余特殊字符并限制长度
-	cleaned := ""
-	remaining := maxLen
-	for _, char := range text {
-		charStr := string(char)
-		if regex.MatchString(charStr) {
-			charStr = "_"
-		}
-		if utf8.RuneCountInString(cleaned)+utf8.RuneCountInString(charStr) > remaining {
-			break
-		}
-		cleaned += charStr
-	}
-
-	// 6. 去除首尾空格
-	cleaned = strings.TrimSpace(cleaned)
-
-	// 7. 如果为空，返回默认值
-	if cleaned == "" {
-		return "无内容"
-	}
-
-	return cleaned
-}
-
 func main() {
-	var nbr, single, output string
-	var retweet, all, printversion, nologo, login, useCookies bool
+	var nbr, single, batch, since, until, minLikes, minRetweets, minViews, includeRegex, excludeRegex, hashtags, filter, minWidth, minHeight, minDuration, maxDuration, orientation, maxFileSize, likes, search, searchTab, list, quoteDepth, output, concurrency, retries, retryWait, retryJitterSec, retryCodes string
+	var retweet, all, excludeReplies, onlySensitive, bookmarks, listMembers, thread, conversation, followQuotes, profileAssets, printversion, nologo, login, useCookies bool
 	op := optionparser.NewOptionParser()
 	op.Banner = "twmd: Apiless twitter media downloader\n\nUsage:"
-	op.On("-u", "--user USERNAME", "User you want to download", &usr)
-	op.On("-t", "--tweet TWEET_ID", "Single tweet to download", &single)
+	op.On("-u", "--user USERNAME", "User you want to download, or a link to their profile", &usr)
+	op.On("-t", "--tweet TWEET_ID", "Single tweet to download, or a link to a tweet, a media, a profile or a list", &single)
+	op.On("--batch FILE", "Download the tweet ids, usernames and links of a file, one per line, - for stdin", &batch)
+	op.On("--thread", "With -t, download the whole thread of the author", &thread)
+	op.On("--conversation", "With -t, download the whole conversation", &conversation)
+	op.On("--likes USERNAME", "Download the tweets liked by a user, needs login", &likes)
+	op.On("--bookmarks", "Download the bookmarks of the logged in account", &bookmarks)
+	op.On("--search QUERY", "Download the results of a search query, needs login", &search)
+	op.On("--search-tab TAB", "Search results: top|latest|media (default top)", &searchTab)
+	op.On("--list ID|URL", "Download the latest tweets of a list", &list)
+	op.On("--list-members", "Download the timeline of every member of the list instead", &listMembers)
 	op.On("-n", "--nbr NBR", "Number of tweets to download", &nbr)
+	op.On("--concurrency N", "Number of media downloaded at the same time (default 4)", &concurrency)
 	op.On("-i", "--img", "Download images only", &imgs)
 	op.On("-v", "--video", "Download videos only", &vidz)
-	op.On("-a", "--all", "Download images and videos", &all)
+	op.On("-g", "--gif", "Download gifs only", &gifs)
+	op.On("-a", "--all", "Download images, videos and gifs", &all)
 	op.On("-r", "--retweet", "Download retweet too", &retweet)
+	op.On("--follow-quotes", "Download the media of quoted tweets too, in OUTPUT/quoted", &followQuotes)
+	op.On("--quote-depth N", "Levels of nested quotes to follow (default 1)", &quoteDepth)
+	op.On("--profile-assets", "Save the avatar, banner and profile of users, keeping their history", &profileAssets)
 	op.On("-z", "--url", "Print media url without download it", &urlOnly)
 	op.On("-R", "--retweet-only", "Download only retweet", &onlyrtw)
 	op.On("-M", "--mediatweet-only", "Download only media tweet", &onlymtw)
 	op.On("-s", "--size SIZE", "Choose size between small|normal|large (default large)", &size)
+	op.On("--video-quality QUALITY", "Video variant: best|worst|<=720p|<=BITRATE (default best)", &videoQuality)
+	op.On("--gif-format FORMAT", "Convert gifs to mp4|gif|webp, needs ffmpeg (default mp4)", &gifFormat)
 	op.On("-U", "--update", "Download missing tweet only", &update)
+	op.On("--archive FILE", "Download archive file (default OUTPUT/twmd_archive.jsonl)", &archiveFile)
+	op.On("--resume", "Resume the previous crawl from its saved cursor", &resume)
+	op.On("--since-id ID", "Stop at this tweet id, 'last' for the newest tweet of the previous crawl", &sinceID)
+	op.On("--since DATE", "Skip tweets posted before DATE (2006-01-02 or 2006-01-02 15:04:05), stopping the crawl there", &since)
+	op.On("--until DATE", "Skip tweets posted after DATE, the whole day included", &until)
+	op.On("--min-likes N", "Skip tweets with fewer likes", &minLikes)
+	op.On("--min-retweets N", "Skip tweets with fewer retweets", &minRetweets)
+	op.On("--min-views N", "Skip tweets with fewer views", &minViews)
+	op.On("--include-regex REGEX", "Skip tweets whose text doesn't match REGEX", &includeRegex)
+	op.On("--exclude-regex REGEX", "Skip tweets whose text matches REGEX", &excludeRegex)
+	op.On("--hashtag TAGS", "Skip tweets without one of these comma separated hashtags", &hashtags)
+	op.On("--exclude-replies", "Skip replies", &excludeReplies)
+	op.On("--only-sensitive", "Skip tweets not marked as sensitive", &onlySensitive)
+	op.On("--min-width PIXELS", "Skip photos and videos narrower than PIXELS", &minWidth)
+	op.On("--min-height PIXELS", "Skip photos and videos shorter than PIXELS", &minHeight)
+	op.On("--orientation SHAPE", "Keep only portrait|landscape|square media", &orientation)
+	op.On("--min-duration SECONDS", "Skip videos and gifs shorter than SECONDS", &minDuration)
+	op.On("--max-duration SECONDS", "Skip videos and gifs longer than SECONDS", &maxDuration)
+	op.On("--max-filesize SIZE", "Skip files larger than SIZE (500K, 50M, 1.5G)", &maxFileSize)
+	op.On("--filter EXPR", "Skip tweets not matching EXPR, as 'likes > 1000 and (is_reply or hashtags contains \"launch\")'", &filter)
 	op.On("-o", "--output DIR", "Output directory", &output)
-	op.On("-f", "--file-format FORMAT", "Formatted name for the downloaded file, {DATE} {USERNAME} {NAME} {TITLE} {ID}", &format)
+	op.On("-f", "--file-format FORMAT", "Name of the media and their sidecars, as {USERNAME}/{DATE}_{ID}_{INDEX:02}", &format)
 	op.On("-d", "--date-format FORMAT", "Apply custom date format. (https://go.dev/src/time/format.go)", &datefmt)
+	op.On("--retries N", "Maximum attempts for each request (default 3)", &retries)
+	op.On("--retry-wait SECONDS", "Base wait between attempts, doubled each retry (default 10)", &retryWait)
+	op.On("--retry-jitter SECONDS", "Maximum random time added to each wait (default 10)", &retryJitterSec)
+	op.On("--retry-status CODES", "Status codes to retry (default 429,500,502,503,504)", &retryCodes)
 	op.On("-L", "--login", "Login (needed for NSFW tweets)", &login)
 	op.On("-C", "--cookies", "Use cookies for authentication", &useCookies)
 	op.On("--auth-token AUTH_TOKEN", "Auth token from browser cookies", &authToken)
@@ -1106,10 +354,25 @@ func main() {
 	op.On("-B", "--no-banner", "Don't print banner", &nologo)
 	op.Exemple("twmd -u Spraytrains -o ~/Downloads -a -r -n 300")
 	op.Exemple("twmd -u Spraytrains -o ~/Downloads -R -U -n 300")
+	op.Exemple("twmd -u Spraytrains -o ~/Downloads -a --resume")
+	op.Exemple("twmd -u Spraytrains -o ~/Downloads -a -U --since-id last")
+	op.Exemple("twmd -u Spraytrains -o ~/Downloads -a --since 2024-03-01 --until 2024-03-31")
+	op.Exemple("twmd -u Spraytrains -o ~/Downloads -v --min-likes 1000 --hashtag launch,space --exclude-replies")
+	op.Exemple("twmd -u Spraytrains -o ~/Downloads -a --filter '(duration > 30s and is_reply and likes > 1000) or hashtags contains \"launch\"'")
+	op.Exemple("twmd -u Spraytrains -o ~/Downloads -v --orientation portrait --max-duration 60 --max-filesize 50M")
+	op.Exemple("twmd --likes Spraytrains -o ~/Downloads -a -C")
+	op.Exemple("twmd --bookmarks -o ~/Downloads -a -U -C")
+	op.Exemple("twmd --search \"#sunset\" --search-tab media -o ~/Downloads -a -C")
+	op.Exemple("twmd --list https://x.com/i/lists/1234567890 -o ~/Downloads -a")
+	op.Exemple("twmd --list 1234567890 --list-members -o ~/Downloads -a -U --since-id last")
+	op.Exemple("twmd --batch links.txt -o ~/Downloads -a -U")
 	op.Exemple("twmd --proxy socks5://127.0.0.1:9050 -t 156170319961391104")
 	op.Exemple("twmd -t 156170319961391104")
 	op.Exemple("twmd -t 156170319961391104 -f \"{DATE} {ID}\"")
 	op.Exemple("twmd -t 156170319961391104 -f \"{DATE} {ID}\" -d \"2006-01-02_15-04-05\"")
+	op.Exemple("twmd -u Spraytrains -o ~/Downloads -a -f \"{TYPE}/{DATE}_{ID}_{INDEX:02}_{TITLE:30}\"")
+	op.Exemple("twmd -t 156170319961391104 --thread")
+	op.Exemple("twmd -t https://x.com/Spraytrains/status/156170319961391104/photo/2")
 	op.Exemple("twmd --auth-token YOUR_AUTH_TOKEN --ct0 YOUR_CT0 -t 156170319961391104")
 	op.Parse()
 
@@ -1119,123 +382,333 @@ func main() {
 	}
 
 	op.Logo("twmd", "elite", nologo)
-	if usr == "" && single == "" {
-		logger.Error("You must specify an user (-u --user) or a tweet (-t --tweet)")
+
+	// -t takes any link, the mode follows what it points to.
+	mediaType, mediaIndex := "", 0
+	if single != "" {
+		target, err := downloader.ParseTarget(single)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		single = ""
+		switch target.Kind {
+		case downloader.TargetTweet:
+			single = target.ID
+			mediaType, mediaIndex = target.MediaType, target.MediaIndex
+		case downloader.TargetUser:
+			usr = target.Username
+		case downloader.TargetList:
+			list = target.ID
+		}
+	}
+	if usr != "" {
+		name, err := parseUser("--user", usr)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		usr = name
+	}
+	if likes != "" {
+		name, err := parseUser("--likes", likes)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		likes = name
+	}
+	if usr == "" && single == "" && batch == "" && likes == "" && !bookmarks && search == "" && list == "" {
+		logger.Error("You must specify an user (-u --user), a tweet (-t --tweet), --batch, liked tweets (--likes), --bookmarks, --search or --list")
 		op.Help()
 		os.Exit(1)
 	}
 	if all {
 		vidz = true
 		imgs = true
+		gifs = true
 	}
-	if !vidz && !imgs && single == "" {
-		logger.Error("You must specify what to download. (-i --img) for images, (-v --video) for videos or (-a --all) for both")
+	if !vidz && !imgs && !gifs && single == "" && batch == "" {
+		logger.Error("You must specify what to download. (-i --img) for images, (-v --video) for videos, (-g --gif) for gifs or (-a --all) for all")
 		op.Help()
 		os.Exit(1)
 	}
-	var re = regexp.MustCompile(`{ID}|{DATE}|{NAME}|{USERNAME}|{TITLE}`)
-	if format != "" && !re.MatchString(format) {
-		logger.Error("You must specify a format (-f --format)")
-		op.Help()
+
+	if (thread || conversation) && single == "" {
+		logger.Error("--thread and --conversation need a tweet (-t --tweet)")
 		os.Exit(1)
 	}
-
-	re = regexp.MustCompile("small|normal|large")
-	if !re.MatchString(size) && size != "orig" {
-		logger.Error("Error in size, setting up to normal")
-		size = ""
+	if thread && conversation {
+		logger.Error("--thread and --conversation can't be used together")
+		os.Exit(1)
 	}
-	if size == "large" {
-		size = "orig"
+
+	listID := ""
+	if list != "" {
+		id, err := parseListID(list)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		listID = id
 	}
 
-	client = &http.Client{
-		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				Timeout: time.Duration(5) * time.Second,
-			}).DialContext,
-			TLSHandshakeTimeout:   time.Duration(5) * time.Second,
-			ResponseHeaderTimeout: 5 * time.Second,
-			DisableKeepAlives:     true,
-		},
+	// The batch is read before logging in, which may read cookies from
+	// stdin.
+	var batchLines []downloader.BatchLine
+	if batch != "" {
+		lines, err := readBatch(batch)
+		if err != nil {
+			logger.Errorf("--batch: %s", err.Error())
+			os.Exit(1)
+		}
+		batchLines = lines
+	}
+
+	opts := downloader.DefaultOptions()
+	opts.Images = imgs
+	opts.Videos = vidz
+	opts.GIFs = gifs
+	opts.Retweets = retweet
+	opts.RetweetsOnly = onlyrtw
+	opts.MediaTweetsOnly = onlymtw
+	opts.FollowQuotes = followQuotes
+	opts.ProfileAssets = profileAssets
+	opts.URLOnly = urlOnly
+	opts.Update = update
+	opts.Size = size
+	opts.FileFormat = format
+	opts.DateFormat = datefmt
+	opts.VideoQuality = videoQuality
+	opts.GIFFormat = gifFormat
+	opts.ArchiveFile = archiveFile
+	opts.Resume = resume
+	opts.SinceID = sinceID
+	if searchTab != "" {
+		opts.SearchTab = searchTab
+	}
+	if since != "" {
+		t, err := parseDate("--since", since)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		opts.Since = t
 	}
-	if proxy != "" {
-		proxyURL, _ := URL.Parse(proxy)
-		client = &http.Client{
-			Transport: &http.Transport{
-				Proxy: http.ProxyURL(proxyURL),
-			},
+	if until != "" {
+		t, err := parseDate("--until", until)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		opts.Until = t
+	}
+	for _, filter := range []struct {
+		flag  string
+		value string
+		min   *int
+	}{
+		{"--min-likes", minLikes, &opts.MinLikes},
+		{"--min-retweets", minRetweets, &opts.MinRetweets},
+		{"--min-views", minViews, &opts.MinViews},
+	} {
+		if filter.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(filter.value)
+		if err != nil || n < 0 {
+			logger.Errorf("%s must be a positive number", filter.flag)
+			os.Exit(1)
+		}
+		*filter.min = n
+	}
+	opts.IncludeRegex = includeRegex
+	opts.ExcludeRegex = excludeRegex
+	if hashtags != "" {
+		opts.Hashtags = strings.Split(hashtags, ",")
+	}
+	opts.ExcludeReplies = excludeReplies
+	opts.OnlySensitive = onlySensitive
+	opts.Filter = filter
+	for _, dimension := range []struct {
+		flag  string
+		value string
+		min   *int
+	}{
+		{"--min-width", minWidth, &opts.MinWidth},
+		{"--min-height", minHeight, &opts.MinHeight},
+	} {
+		if dimension.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(dimension.value)
+		if err != nil || n < 0 {
+			logger.Errorf("%s must be a positive number", dimension.flag)
+			os.Exit(1)
 		}
+		*dimension.min = n
 	}
-
-	scraper = twitterscraper.New()
-	scraper.WithReplies(true)
-	scraper.SetProxy(proxy)
-
-	// Modified login handling
-	if login || useCookies {
-		Login(useCookies)
+	opts.Orientation = orientation
+	if minDuration != "" {
+		v, err := parseSeconds("--min-duration", minDuration)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		opts.MinDuration = v
 	}
-
-	if single != "" {
-		if output == "" {
-			output = "./"
-		} else {
-			os.MkdirAll(output, os.ModePerm)
+	if maxDuration != "" {
+		v, err := parseSeconds("--max-duration", maxDuration)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
 		}
-		singleTweet(output, single)
-		os.Exit(0)
+		opts.MaxDuration = v
 	}
-	if nbr == "" {
-		nbr = "3000"
+	if maxFileSize != "" {
+		v, err := parseFileSize(maxFileSize)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		opts.MaxFileSize = v
 	}
-	if output != "" {
-		output = output + "/" + usr
-	} else {
-		output = usr
+	if !opts.Since.IsZero() && !opts.Until.IsZero() && !opts.Since.Before(opts.Until) {
+		logger.Error("--since must be before --until")
+		os.Exit(1)
 	}
-	if vidz {
-		os.MkdirAll(output+"/video", os.ModePerm)
+	opts.Proxy = proxy
+	opts.Logger = logger
+	if output != "" {
+		opts.Output = output
 	}
-	if imgs {
-		os.MkdirAll(output+"/img", os.ModePerm)
+	if nbr != "" {
+		n, err := strconv.Atoi(nbr)
+		if err != nil || n < 1 {
+			logger.Error("--nbr must be a positive number")
+			os.Exit(1)
+		}
+		opts.MaxTweets = n
 	}
-	nbrs, _ := strconv.Atoi(nbr)
-	wg := sync.WaitGroup{}
 
-	var tweets <-chan *twitterscraper.TweetResult
-	if onlymtw {
-		tweets = scraper.GetMediaTweets(context.Background(), usr, nbrs)
-	} else {
-		tweets = scraper.GetTweets(context.Background(), usr, nbrs)
+	if concurrency != "" {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil || n < 1 {
+			logger.Error("--concurrency must be a positive number")
+			os.Exit(1)
+		}
+		opts.Concurrency = n
 	}
 
-	for tweet := range tweets {
-		waitForRateLimit()
+	if quoteDepth != "" {
+		n, err := strconv.Atoi(quoteDepth)
+		if err != nil || n < 1 {
+			logger.Error("--quote-depth must be a positive number")
+			os.Exit(1)
+		}
+		opts.QuoteDepth = n
+	}
 
-		if tweet.Error != nil {
-			if strings.Contains(tweet.Error.Error(), "429") || strings.Contains(tweet.Error.Error(), "Too Many Requests") {
-				if !handle429Error() {
-					logger.Errorf("429 error persisted after cooldown, skipping tweet: %s", tweet.ID)
-					continue
-				}
-			} else {
-				logger.Errorf("Error fetching tweet: %s", tweet.Error.Error())
-				continue
-			}
+	if retries != "" {
+		n, err := strconv.Atoi(retries)
+		if err != nil || n < 1 {
+			logger.Error("--retries must be a positive number")
+			os.Exit(1)
+		}
+		opts.MaxRetries = n
+	}
+	if retryWait != "" {
+		n, err := strconv.Atoi(retryWait)
+		if err != nil || n < 0 {
+			logger.Error("--retry-wait must be a number of seconds")
+			os.Exit(1)
 		}
+		opts.RetryWait = time.Duration(n) * time.Second
+	}
+	if retryJitterSec != "" {
+		n, err := strconv.Atoi(retryJitterSec)
+		if err != nil || n < 0 {
+			logger.Error("--retry-jitter must be a number of seconds")
+			os.Exit(1)
+		}
+		opts.RetryJitter = time.Duration(n) * time.Second
+	}
+	if retryCodes != "" {
+		codes, err := parseRetryStatus(retryCodes)
+		if err != nil {
+			logger.Errorf("--retry-status: %s", err.Error())
+			os.Exit(1)
+		}
+		opts.RetryStatus = codes
+	}
 
-		reset429Count()
-		checkAndPauseForBatch()
+	dl, err := downloader.New(opts)
+	if err != nil {
+		logger.Error(err.Error())
+		op.Help()
+		os.Exit(1)
+	}
 
-		if vidz {
-			wg.Add(1)
-			go videoUser(&wg, tweet, output, retweet)
+	// Modified login handling, likes, bookmarks and search are only
+	// available when logged in
+	if login || useCookies || likes != "" || bookmarks || search != "" {
+		Login(dl, useCookies)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// A second signal kills twmd.
+		stop()
+	}()
+
+	var result *downloader.Result
+	var batchItems []downloader.BatchItem
+	if batch != "" {
+		result, batchItems, err = dl.DownloadBatch(ctx, batchLines)
+	} else if single != "" && thread {
+		result, err = dl.DownloadThread(ctx, single)
+	} else if single != "" && conversation {
+		result, err = dl.DownloadConversation(ctx, single)
+	} else if single != "" && mediaIndex > 0 {
+		result, err = dl.DownloadTweetMedia(ctx, single, mediaType, mediaIndex)
+	} else if single != "" {
+		result, err = dl.DownloadTweet(ctx, single)
+	} else if listID != "" {
+		if listMembers {
+			result, err = dl.DownloadListMembers(ctx, listID)
+		} else {
+			result, err = dl.DownloadList(ctx, listID)
+		}
+	} else if search != "" {
+		result, err = dl.DownloadSearch(ctx, search)
+	} else if bookmarks {
+		result, err = dl.DownloadBookmarks(ctx)
+	} else if likes != "" {
+		result, err = dl.DownloadLikes(ctx, likes)
+	} else {
+		result, err = dl.DownloadUser(ctx, usr)
+	}
+	if batch != "" {
+		printBatchSummary(batchItems)
+	}
+	printSummary(result)
+	if errors.Is(err, context.Canceled) {
+		if single == "" {
+			logger.Warn("Interrupted, run again with --resume to continue")
+		} else {
+			logger.Warn("Interrupted")
 		}
-		if imgs {
-			wg.Add(1)
-			go photoUser(&wg, tweet, output, retweet)
+		os.Exit(130)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	for _, item := range batchItems {
+		if item.Err != nil {
+			os.Exit(1)
 		}
 	}
-	wg.Wait()
 }